@@ -0,0 +1,136 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/hahutton/stor/providers"
+	"github.com/spf13/cobra"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+var catRange string
+
+// catCmd represents the cat command
+var catCmd = &cobra.Command{
+	Use:   "cat [//alias/]name...",
+	Short: "Print blobs or local files to stdout",
+	Long: `Print blobs or local files to stdout one after another.
+
+Each blob is fetched in blocks with parallel ranged GETs, as cp does, and the
+blocks are written to stdout in order as they arrive.
+
+--range start-end prints only the bytes from start to end inclusive of each
+name, e.g. --range 0-1023 for the first KB. Leave off end to print from start
+to the end. A range running past the end of a name stops at its end.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		start, end, err := parseRange(catRange)
+		if err != nil {
+			return err
+		}
+		stdout, err := providers.Create(providers.STDIO)
+		if err != nil {
+			return err
+		}
+
+		tokenBucket := providers.InitTokenBucket()
+		for _, arg := range args {
+			alias, pathName, err := providers.Parse(arg)
+			if err != nil {
+				return err
+			}
+			provider, err := providers.Create(alias)
+			if err != nil {
+				return err
+			}
+			info, err := provider.Stat(pathName)
+			if err != nil {
+				return err
+			}
+			if info.IsDir {
+				return configError("%s is a directory", arg)
+			}
+
+			if start > 0 && start >= info.Length {
+				return configError("range %s starts past the end of %s (%d bytes)", catRange, arg, info.Length)
+			}
+			length := info.Length - start
+			if end >= 0 && end < info.Length {
+				length = end + 1 - start
+			}
+
+			err = catBlob(provider, pathName, start, length, stdout, tokenBucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// catBlob streams length bytes of name from start to stdout in blocks of blockSize.
+func catBlob(provider providers.Provider, name string, start int64, length int64, stdout providers.Provider, tokenBucket chan int) error {
+	blockSize := providers.BlockSize()
+	blockCount := int((length + int64(blockSize) - 1) / int64(blockSize))
+	jww.INFO.Printf("cat %s from %d for %d bytes", name, start, length)
+
+	transferChan := make(chan *providers.Block, blockCount)
+	err := provider.OpenRange(name, transferChan, tokenBucket, start, length, blockSize)
+	if err != nil {
+		return err
+	}
+	return stdout.Create(providers.STDIO, transferChan, blockCount, length, tokenBucket, false)
+}
+
+// parseRange parses start-end or start- into byte offsets. end is -1 when left
+// off and the range runs to the end. An empty range is everything.
+func parseRange(byteRange string) (int64, int64, error) {
+	if byteRange == "" {
+		return 0, -1, nil
+	}
+
+	parts := strings.SplitN(byteRange, "-", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return 0, 0, configError("range %s is not start-end", byteRange)
+	}
+	start, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, configError("range %s has a bad start", byteRange)
+	}
+	if parts[1] == "" {
+		return start, -1, nil
+	}
+	end, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || end < start {
+		return 0, 0, configError("range %s has a bad end", byteRange)
+	}
+	return start, end, nil
+}
+
+func init() {
+	RootCmd.AddCommand(catCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// catCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	catCmd.Flags().StringVar(&catRange, "range", "", "print only bytes start-end (inclusive) of each name")
+}
@@ -0,0 +1,50 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import "testing"
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		byteRange  string
+		start, end int64
+		wantErr    bool
+	}{
+		{"", 0, -1, false},
+		{"0-99", 0, 99, false},
+		{"100-", 100, -1, false},
+		{"5-5", 5, 5, false},
+		{"0-", 0, -1, false},
+		{"1099511627776-1099511627779", 1099511627776, 1099511627779, false},
+		{"-100", 0, 0, true},
+		{"100", 0, 0, true},
+		{"10-5", 0, 0, true},
+		{"a-5", 0, 0, true},
+		{"5-b", 0, 0, true},
+		{"-5-10", 0, 0, true},
+		{"5-10-15", 0, 0, true},
+	}
+	for _, test := range tests {
+		start, end, err := parseRange(test.byteRange)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseRange(%q) = %d, %d, want an error", test.byteRange, start, end)
+			}
+			continue
+		}
+		if err != nil || start != test.start || end != test.end {
+			t.Errorf("parseRange(%q) = %d, %d, %v, want %d, %d", test.byteRange, start, end, err, test.start, test.end)
+		}
+	}
+}
@@ -14,10 +14,14 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hahutton/stor/providers"
@@ -27,6 +31,7 @@ import (
 
 var dryRun bool
 var recurse bool
+var resume bool
 
 // cpCmd represents the cp command
 var cpCmd = &cobra.Command{
@@ -41,97 +46,362 @@ names and keys.
 Local file system [//file/]source_file... can be a file name(s) or directories. If directory and 
 not -R then it will be skipped. The typical shell expands * before passing it to any cmd. stor handles this
 on the local file provider by handling multiple sources with the last positional arg being the target.
+When there is more than one source, or a prefix matches more than one blob, the target must end in /.
 
-Object store //alias/source_file can be a file name or a prefix.
-The match semantics are specific to the cloud providers.
+Object store //alias/source_file names one blob exactly. With -R, or when it
+ends in /, it is a prefix and every blob beginning with it is copied.
+The match semantics are specific to the cloud providers. Copying from an
+object store to the local filesystem downloads each blob with parallel
+ranged reads into the target.
 
-The prefix semantics match the substring of characters at the beginning of the key.`,
+The prefix semantics match the substring of characters at the beginning of the key.
+Shell style wildcards (*, ? and [...]) may follow the prefix, e.g. '//alias/logs/2024-*.csv'.
+The prefix before the first wildcard is matched by the provider and the rest is
+filtered by stor. Quote such arguments so the shell passes them through untouched.
+
+--resume continues interrupted uploads to an object store. Blocks already put by an
+earlier attempt with the same blockSize are kept when their content is the same, as
+each block is named by its position and MD5, and only the missing or changed blocks
+are uploaded before the block list is committed.
+
+Each cp journals its files under ~/.stor/jobs until all of them are copied. A copy
+that fails or is interrupted can be inspected and continued with stor jobs.
+
+- as the source uploads stdin to one blob and as the target downloads one blob to
+stdout, e.g. 'pg_dump | stor cp - //alias/db.sql' and 'stor cp //alias/db.sql - | psql'.
+stdin is split into blocks as it is read and the block list is committed at EOF.
+Streams can't be read twice so they are not journaled and can't be resumed.`,
 	Args: cobra.MinimumNArgs(2),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		start := time.Now()
 
 		argCount := len(args)
 		targetPosition := argCount - 1
 
-		sourceAlias, sourcePathName := providers.Parse(args[0])
-		targetAlias, targetPathName := providers.Parse(args[targetPosition])
+		sourceAlias, sourcePathName, err := providers.Parse(args[0])
+		if err != nil {
+			return err
+		}
+		targetAlias, targetPathName, err := providers.Parse(args[targetPosition])
+		if err != nil {
+			return err
+		}
 		jww.INFO.Printf("sourceAlias: %s, sourcePathName: %s", sourceAlias, sourcePathName)
 		jww.INFO.Printf("targetAlias: %s, targetPathName: %s", targetAlias, targetPathName)
 
-		sourceProvider := providers.Create(sourceAlias)
-		targetProvider := providers.Create(targetAlias)
+		sourceProvider, err := providers.Create(sourceAlias)
+		if err != nil {
+			return err
+		}
+		targetProvider, err := providers.Create(targetAlias)
+		if err != nil {
+			return err
+		}
 
-		if sourceProvider.ProviderName() != "file" && targetProvider.ProviderName() != "azure" {
-			jww.ERROR.Println("cp currently implements file source and azure target only")
-			os.Exit(1)
+		if sourceProvider.ProviderName() == "stream" || targetProvider.ProviderName() == "stream" {
+			if argCount != 2 {
+				return configError("cp with - takes one source and one target")
+			}
+			err = streamCopy(sourceProvider, sourcePathName, targetProvider, targetPathName)
+			jww.INFO.Printf("Elapsed: %v\n", time.Since(start))
+			return err
+		}
+
+		upload := sourceProvider.ProviderName() == "file" && targetProvider.ProviderName() == "azure"
+		download := sourceProvider.ProviderName() == "azure" && targetProvider.ProviderName() == "file"
+		if !upload && !download {
+			return errors.New("cp currently implements file to azure and azure to file only")
 		}
 
 		var sourceInfos []*providers.BlobInfo
 		for _, arg := range args[:targetPosition] {
 			jww.INFO.Println("arg:", arg)
-			statInfo := sourceProvider.Stat(arg)
-			if !statInfo.IsDir {
-				sourceInfos = append(sourceInfos, statInfo)
-			} else {
-				if recurse {
-					filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
-						if err != nil {
-							fmt.Printf("prevent panic by handling failure accessing a path %q: %v\n", path, err)
-							return err
-						}
-						if info.Mode().IsRegular() {
-							blobInfo := &providers.BlobInfo{}
-							blobInfo.Name = info.Name()
-							blobInfo.PathName = path
-							blobInfo.IsDir = info.IsDir()
-							blobInfo.Length = info.Size()
-							blobInfo.LastModified = info.ModTime()
-							sourceInfos = append(sourceInfos, blobInfo)
-						}
-						return nil
-					})
+			if download {
+				_, pathName, err := providers.Parse(arg)
+				if err != nil {
+					return err
+				}
+				//A plain name is that blob alone, not every blob it is a prefix of
+				if !recurse && !isDir(pathName) && !strings.ContainsAny(pathName, "*?[\\") {
+					sourceInfo, err := sourceProvider.Stat(pathName)
+					if err != nil {
+						return err
+					}
+					if sourceInfo.IsDir {
+						jww.INFO.Printf("Skipping prefix %s without -R", arg)
+						continue
+					}
+					sourceInfos = append(sourceInfos, sourceInfo)
+					continue
+				}
+
+				//Blob stores have no directories so the source is matched as a prefix or glob
+				matches, err := sourceProvider.Glob(pathName)
+				if err != nil {
+					return err
 				}
+				if len(matches) == 0 {
+					return &providers.Error{Kind: providers.NotFound, Message: "no match for " + arg}
+				}
+				sourceInfos = append(sourceInfos, matches...)
+				continue
+			}
+
+			localInfos, err := localSources(sourceProvider, arg, recurse)
+			if err != nil {
+				return err
 			}
+			sourceInfos = append(sourceInfos, localInfos...)
+		}
+
+		//Like cp(1) several sources need a directory to go in rather than overwriting one name
+		if len(sourceInfos) > 1 && !isDir(targetPathName) {
+			return configError("%d sources but target %s is not a directory. End it with / to copy into it.", len(sourceInfos), args[targetPosition])
 		}
 
 		if dryRun {
 			for _, sourceInfo := range sourceInfos {
 				fmt.Printf("%s\n", sourceInfo.PathName)
 			}
-			os.Exit(0)
+			return nil
 		}
 
-		for _, sourceInfo := range sourceInfos {
+		//Like cp(1) an existing directory named without a trailing / gets the file in it
+		intoDir := targetProvider.ProviderName() == "file" && !isDir(targetPathName) && isLocalDir(targetPathName)
 
-			blockCount, blockSize := providers.CalculateBlocks(sourceInfo)
+		files := make([]*jobFile, len(sourceInfos))
+		for i, sourceInfo := range sourceInfos {
+			targetName := targetFor(targetPathName, sourceInfo.PathName)
+			if intoDir {
+				targetName = filepath.Join(targetPathName, path.Base(sourceInfo.PathName))
+			}
+			if (intoDir || isDir(targetPathName)) && targetProvider.ProviderName() == "file" && !underRoot(targetPathName, targetName) {
+				return configError("%s would be copied to %s, outside %s", sourceInfo.PathName, targetName, args[targetPosition])
+			}
+			files[i] = &jobFile{
+				Source:  journalPath(sourceProvider, sourceInfo.PathName),
+				Target:  journalPath(targetProvider, targetName),
+				Size:    sourceInfo.Length,
+				ModTime: sourceInfo.LastModified,
+				info:    sourceInfo,
+			}
+		}
+
+		j := startJob("cp", args, sourceAlias, targetAlias, files)
+		err = runJob(j, sourceProvider, targetProvider, resume)
+		jww.INFO.Printf("Elapsed: %v\n", time.Since(start))
+		return err
+	},
+}
 
-			var targetName string
+// runJob copies each file of the job that is not done yet and journals the
+// outcome. The journal is removed once every file is done and kept otherwise
+// so the job can be resumed. With resume uploads may keep the blocks an
+// interrupted attempt already put.
+//
+// Files are copied concurrently and every request of every file draws on the
+// one token bucket, so max_concurrency bounds the requests in flight across
+// the whole command. There are never more files in flight than tokens since a
+// file without a token can't make progress anyway.
+func runJob(j *job, sourceProvider providers.Provider, targetProvider providers.Provider, resume bool) error {
+	defer j.close()
 
-			if isDir(targetPathName) {
-				targetName = fmt.Sprintf("%s%s", targetPathName, sourceInfo.PathName)
-			} else {
-				targetName = targetPathName
+	var pending []int
+	for i, file := range j.Files {
+		if file.Status != statusDone {
+			pending = append(pending, i)
+		}
+	}
+
+	var mu sync.Mutex
+	var failures []string
+	var firstErr error
+	failed := func(file *jobFile, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		jww.ERROR.Printf("Failed to copy %s to %s: %v", file.Source, file.Target, err)
+		failures = append(failures, file.Source)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	tokenBucket := providers.InitTokenBucket()
+	workers := cap(tokenBucket)
+	if workers > len(pending) {
+		workers = len(pending)
+	}
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				file := j.Files[i]
+				err := copyJobFile(sourceProvider, targetProvider, file, tokenBucket, resume)
+				j.record(i, err)
+				if err != nil {
+					failed(file, err)
+				}
 			}
+		}()
+	}
+	for _, i := range pending {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
 
-			tokenBucket := providers.InitTokenBucket()
+	if firstErr != nil {
+		sort.Strings(failures)
+		fmt.Fprintln(os.Stderr, "Failed:")
+		for _, failure := range failures {
+			fmt.Fprintln(os.Stderr, failure)
+		}
+		if j.journaled() {
+			fmt.Fprintln(os.Stderr, "Resume with: stor jobs resume", j.Id)
+		}
+		return fmt.Errorf("%d of %d files failed to copy. First failure: %w", len(failures), len(pending), firstErr)
+	}
+	return j.remove()
+}
 
-			//starting with blockCount here since that shouldn't backpressure at all
-			//might want to use this to govern memory usage at some point??
-			//at that point introduce config var to "dial" this
-			transferChan := make(chan *providers.Block, blockCount) //TODO this could kill on memory. fast big read
-			sourceProvider.Open(sourceInfo.PathName, transferChan, tokenBucket, blockCount, blockSize)
-			jww.INFO.Println(targetName)
-			targetProvider.Create(targetName, transferChan, blockCount, tokenBucket)
+// copyJobFile copies one file of a job. Files planned by an earlier run are
+// stat'd again first since only the journal is left of their BlobInfo.
+func copyJobFile(sourceProvider providers.Provider, targetProvider providers.Provider, file *jobFile, tokenBucket chan int, resume bool) error {
+	sourceInfo := file.info
+	reuse := resume
+	if sourceInfo == nil {
+		token := <-tokenBucket
+		var err error
+		sourceInfo, err = sourceProvider.Stat(file.Source)
+		tokenBucket <- token
+		if err != nil {
+			return err
 		}
-		duration := time.Since(start)
-		jww.INFO.Printf("Elapsed: %v\n", duration)
-	},
+		if sourceInfo.Length != file.Size || !sourceInfo.LastModified.Equal(file.ModTime) {
+			//Few if any blocks of the old content can still be used
+			jww.WARN.Printf("%s changed since the job was planned. Copying all of it as it is now.", file.Source)
+			reuse = false
+		}
+	}
+	return copyBlob(sourceProvider, targetProvider, sourceInfo, file.Target, tokenBucket, reuse)
+}
+
+// copyBlob streams one source blob or file into targetName. Its requests share
+// tokenBucket with every other copy in flight. With resume the target may keep
+// blocks an interrupted copy already put.
+func copyBlob(sourceProvider providers.Provider, targetProvider providers.Provider, sourceInfo *providers.BlobInfo, targetName string, tokenBucket chan int, resume bool) error {
+	blockCount, blockSize, err := providers.CalculateBlocks(sourceInfo)
+	if err != nil {
+		return err
+	}
+
+	//The channel only holds pointers. Block buffers come from providers.GetBuffer,
+	//which holds a fast reader back once max_memory is in flight.
+	bufferSize := blockCount
+	if blockCount == providers.UNKNOWN {
+		bufferSize = providers.Concurrency()
+	}
+	transferChan := make(chan *providers.Block, bufferSize)
+	err = sourceProvider.Open(sourceInfo.PathName, transferChan, tokenBucket, blockCount, blockSize)
+	if err != nil {
+		return err
+	}
+	jww.INFO.Println(targetName)
+	return targetProvider.Create(targetName, transferChan, blockCount, sourceInfo.Length, tokenBucket, resume)
+}
+
+// streamCopy uploads stdin to a blob or downloads a blob to stdout.
+func streamCopy(sourceProvider providers.Provider, sourcePathName string, targetProvider providers.Provider, targetPathName string) error {
+	upload := sourceProvider.ProviderName() == "stream" && targetProvider.ProviderName() == "azure"
+	download := sourceProvider.ProviderName() == "azure" && targetProvider.ProviderName() == "stream"
+	if !upload && !download {
+		return errors.New("cp with - currently streams to and from azure only")
+	}
+	if resume {
+		return configError("stdin and stdout can't be resumed")
+	}
+	if upload && isDir(targetPathName) {
+		return configError("stdin needs a blob name to upload to, not %s", targetPathName)
+	}
+	sourceInfo, err := sourceProvider.Stat(sourcePathName)
+	if err != nil {
+		return err
+	}
+	if sourceInfo.IsDir {
+		return configError("%s is a prefix. Only one blob can be copied to stdout.", sourcePathName)
+	}
+
+	if dryRun {
+		fmt.Printf("%s\n", sourceInfo.PathName)
+		return nil
+	}
+	return copyBlob(sourceProvider, targetProvider, sourceInfo, targetPathName, providers.InitTokenBucket(), false)
+}
+
+// localSources is the file named by arg or, with recurse, every regular file
+// under the directory named by arg. Directories are skipped without recurse.
+func localSources(provider providers.Provider, arg string, recurse bool) ([]*providers.BlobInfo, error) {
+	statInfo, err := provider.Stat(arg)
+	if err != nil {
+		return nil, err
+	}
+	if !statInfo.IsDir {
+		return []*providers.BlobInfo{statInfo}, nil
+	}
+	if !recurse {
+		jww.INFO.Printf("Skipping directory %s without -R", arg)
+		return nil, nil
+	}
+
+	var sourceInfos []*providers.BlobInfo
+	err = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			blobInfo := &providers.BlobInfo{}
+			blobInfo.Name = info.Name()
+			blobInfo.PathName = path
+			blobInfo.IsDir = info.IsDir()
+			blobInfo.Length = info.Size()
+			blobInfo.LastModified = info.ModTime()
+			blobInfo.BlobType = "FileSystem"
+			sourceInfos = append(sourceInfos, blobInfo)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sourceInfos, nil
+}
+
+// targetFor is the name cp gives the copy of sourcePathName. A target ending
+// in / is a directory or prefix the source path is appended to.
+func targetFor(targetPathName string, sourcePathName string) string {
+	if isDir(targetPathName) {
+		return fmt.Sprintf("%s%s", targetPathName, sourcePathName)
+	}
+	return targetPathName
 }
 
 func isDir(path string) bool {
 	return strings.HasSuffix(path, "/")
 }
 
+// underRoot reports whether the local name stays within root once cleaned, so
+// a blob name with .. segments can't be written, or deleted, outside it.
+func underRoot(root string, name string) bool {
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(name))
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func init() {
 	RootCmd.AddCommand(cpCmd)
 
@@ -145,4 +415,5 @@ func init() {
 	// is called directly, e.g.:
 	cpCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "show set of blobs to be copied but don't copy")
 	cpCmd.Flags().BoolVarP(&recurse, "Recurse", "R", false, "Recurse directories mainly for local file provider")
+	cpCmd.Flags().BoolVar(&resume, "resume", false, "reuse blocks uploaded by an interrupted copy")
 }
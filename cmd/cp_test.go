@@ -0,0 +1,41 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import "testing"
+
+func TestUnderRoot(t *testing.T) {
+	tests := []struct {
+		root, name string
+		want       bool
+	}{
+		{"out/", "out/logs/a.txt", true},
+		{"out/", "out/a.txt", true},
+		{".", "a.txt", true},
+		{"./", "./logs/a.txt", true},
+		{"/tmp/out", "/tmp/out/a..b", true},
+		{"out/", "out/..a/b", true},
+		{"out/", "out/logs/../a.txt", true},
+		{"out/", "out/../a.txt", false},
+		{"out/", "out/logs/../../a.txt", false},
+		{".", "..", false},
+		{"/tmp/out/", "/tmp/out/../../etc/passwd", false},
+		{"/tmp/out", "/tmp/out", true},
+	}
+	for _, test := range tests {
+		if got := underRoot(test.root, test.name); got != test.want {
+			t.Errorf("underRoot(%q, %q) = %v, want %v", test.root, test.name, got, test.want)
+		}
+	}
+}
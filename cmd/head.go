@@ -0,0 +1,183 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/hahutton/stor/providers"
+	"github.com/spf13/cobra"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+var headBytes int64
+var headLines int
+
+// headCmd represents the head command
+var headCmd = &cobra.Command{
+	Use:   "head [//alias/]name...",
+	Short: "Print the first bytes or lines of blobs or local files",
+	Long: `Print the first 10 lines of each blob or local file to stdout.
+
+-n N prints the first N lines and -c N the first N bytes. Only the start of the
+blob is fetched with ranged GETs. Lines are read and printed a block at a time
+until enough have been found, so memory use doesn't grow with their length.
+
+With more than one name each is preceded by a ==> name <== header like head(1).`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if headBytes < 0 || headLines < 0 {
+			return configError("head needs a count of 0 or more")
+		}
+		byBytes := cmd.Flags().Changed("bytes")
+
+		tokenBucket := providers.InitTokenBucket()
+		for i, arg := range args {
+			provider, pathName, info, err := partialSource(arg)
+			if err != nil {
+				return err
+			}
+			if len(args) > 1 {
+				printHeader(i, arg)
+			}
+
+			if byBytes {
+				length := headBytes
+				if length > info.Length {
+					length = info.Length
+				}
+				err = writeRange(provider, pathName, 0, length, tokenBucket)
+			} else {
+				err = headLinesOf(provider, pathName, info.Length, headLines, tokenBucket)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// headLinesOf prints the first lines of name, which is length bytes long. Each
+// block is written out as soon as it has been searched for newlines.
+func headLinesOf(provider providers.Provider, name string, length int64, lines int, tokenBucket chan int) error {
+	blockSize := int64(providers.BlockSize())
+	for offset := int64(0); lines > 0 && offset < length; offset += blockSize {
+		chunk := blockSize
+		if chunk > length-offset {
+			chunk = length - offset
+		}
+		data, err := readBlockAt(provider, name, offset, chunk, tokenBucket)
+		if err != nil {
+			return err
+		}
+
+		end := 0
+		for lines > 0 && end < len(data) {
+			newline := bytes.IndexByte(data[end:], '\n')
+			if newline < 0 {
+				end = len(data)
+				break
+			}
+			end += newline + 1
+			lines--
+		}
+		_, err = os.Stdout.Write(data[:end])
+		providers.PutBuffer(data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partialSource is the provider, path and properties of a name to read part of.
+func partialSource(arg string) (providers.Provider, string, *providers.BlobInfo, error) {
+	alias, pathName, err := providers.Parse(arg)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	provider, err := providers.Create(alias)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	info, err := provider.Stat(pathName)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if info.IsDir {
+		return nil, "", nil, configError("%s is a directory", arg)
+	}
+	return provider, pathName, info, nil
+}
+
+// readBlockAt reads length bytes of name from start, no more than a block,
+// with one ranged read. The bytes are in a buffer from the pool, which the
+// caller puts back with providers.PutBuffer.
+func readBlockAt(provider providers.Provider, name string, start int64, length int64, tokenBucket chan int) ([]byte, error) {
+	jww.INFO.Printf("Reading %s from %d for %d bytes", name, start, length)
+	stream := make(chan *providers.Block, 1)
+	err := provider.OpenRange(name, stream, tokenBucket, start, length, providers.BlockSize())
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	for block := range stream {
+		if block.Err != nil {
+			err = block.Err
+			continue
+		}
+		data = block.Bytes
+	}
+	if err != nil {
+		providers.PutBuffer(data)
+		return nil, err
+	}
+	return data, nil
+}
+
+// writeRange prints length bytes of name from start to stdout.
+func writeRange(provider providers.Provider, name string, start int64, length int64, tokenBucket chan int) error {
+	stdout, err := providers.Create(providers.STDIO)
+	if err != nil {
+		return err
+	}
+	return catBlob(provider, name, start, length, stdout, tokenBucket)
+}
+
+// printHeader separates the output of several names as head(1) and tail(1) do.
+func printHeader(i int, name string) {
+	if i > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("==> %s <==\n", name)
+}
+
+func init() {
+	RootCmd.AddCommand(headCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// headCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	headCmd.Flags().Int64VarP(&headBytes, "bytes", "c", 0, "print the first N bytes")
+	headCmd.Flags().IntVarP(&headLines, "lines", "n", 10, "print the first N lines")
+}
@@ -54,6 +54,55 @@ const skeleton_config_file string = `# Config for stor
 #       key: TA+RADLa2PfySyyPjEaUp+P8LRXxKeRxEBqQLnRunbwZggKNPCoQD5zTxWJ4OkHMoKD2o7CfRPrNTc/05x5GTQ==
 #     file:
 #       provider: file
+#
+# Instead of the account key an alias can use a shared access signature (SAS). Either the query string:
+#     sasblah:
+#       provider: azure
+#       name: blah
+#       accountName: hhblah
+#       sas: "sv=2017-11-09&ss=b&srt=co&sp=rwdl&se=2018-12-31T00:00:00Z&sig=..."
+# or the full container SAS url from which the account and container name are taken:
+#     sasurl:
+#       provider: azure
+#       sas: "https://hhblah.blob.core.windows.net/blah?sv=2017-11-09&sr=c&sp=rwdl&sig=..."
+#
+# Or an Azure AD service principal with a role such as Storage Blob Data Contributor. The bearer token
+# is fetched with the client credentials grant and refreshed before it expires. Use clientSecret or
+# clientCertificate, a PEM file holding both the certificate and its RSA private key.
+# authorityHost (default https://login.microsoftonline.com) or a full tokenEndpoint can be set to point
+# at other clouds or a local stand-in token server.
+#     aadblah:
+#       provider: azure
+#       name: blah
+#       accountName: hhblah
+#       tenantId: 00000000-0000-0000-0000-000000000000
+#       clientId: 00000000-0000-0000-0000-000000000000
+#       clientSecret: <your client secret>
+#      #clientCertificate: /path/to/client.pem
+#      #tokenEndpoint: http://127.0.0.1:8080/token
+#
+# Accounts outside the public cloud set suffix, e.g. blob.core.chinacloudapi.cn or blob.core.usgovcloudapi.net,
+# in place of the default blob.core.windows.net. endpoint replaces the whole account url for private endpoints
+# and emulators. A path style endpoint like Azurite's carries the account name so accountName can be left out.
+#     azurite:
+#       provider: azure
+#       name: blah
+#       endpoint: http://127.0.0.1:10000/devstoreaccount1
+#       key: Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==
+#     chinablah:
+#       provider: azure
+#       name: blah
+#       accountName: hhblah
+#       suffix: blob.core.chinacloudapi.cn
+#       key: <your storage_account_key here>
+#
+# A storage connection string can stand in for accountName, key, sas and endpoint. Settings given on the
+# alias win over the connection string. BlobEndpoint, SharedAccessSignature and UseDevelopmentStorage=true
+# are understood as well.
+#     connblah:
+#       provider: azure
+#       name: blah
+#       connectionString: "DefaultEndpointsProtocol=https;AccountName=hhblah;AccountKey=...;EndpointSuffix=core.windows.net"
 
 
 aliases:
@@ -77,6 +126,32 @@ aliases:
 #blockSize: 5242880   #5MB
 blockSize: 10485760  #10MB
 #blockSize: 20971520  #20MB
+
+# max_concurrency is the number of requests in flight across the whole command, shared by every file being
+# copied. It defaults to 10 per CPU.
+
+#max_concurrency: 32
+
+# max_memory caps the bytes held in blocks read but not yet written or uploaded, across every file being copied.
+# Reading waits while it is reached, so a fast disk can't outrun a slow network. It defaults to twice
+# max_concurrency * blockSize and is never less than one block.
+
+#max_memory: 268435456  #256MB
+
+# reader_concurrency is the number of blocks of one local file read at once at their own offsets. Raise it for
+# NVMe drives and network filesystems where a single reader can't keep the uploads busy. It defaults to 4.
+
+#reader_concurrency: 16
+
+# Uploads up to putBlobThreshold bytes go in a single Put Blob request instead of a Put Block per block and a
+# Put Block List. It defaults to blockSize, which is also its maximum, so the one request is a single block.
+# Set it lower to upload only smaller files that way. 0 always uploads in blocks.
+
+#putBlobThreshold: 1048576  #1MB
+
+# cp journals its progress under $HOME/.stor/jobs so interrupted copies can be resumed with stor jobs resume.
+
+#jobsDir: /var/lib/stor/jobs
 `
 
 // initCmd represents the init command
@@ -0,0 +1,175 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hahutton/stor/providers"
+	"github.com/spf13/cobra"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+var jobsShowAll bool
+
+// jobsCmd represents the jobs command
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "Inspect and resume interrupted copies",
+	Long: `Inspect and resume interrupted copies.
+
+Every cp and sync journals its planned files and the outcome of each one under
+~/.stor/jobs/<id> (or the jobsDir config setting). The journal is removed when
+every file has been copied, so the jobs left are the ones that failed or were
+interrupted.`,
+}
+
+var jobsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List interrupted and failed jobs",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jobs, err := listJobs()
+		if err != nil {
+			return err
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+
+		fmt.Printf("%-22s %-12s %8s %8s %8s  %s\n", "ID", "CREATED", "DONE", "FAILED", "PENDING", "COMMAND")
+		for _, j := range jobs {
+			done, failed, pending := j.counts()
+			fmt.Printf("%-22s %-12s %8d %8d %8d  stor %s %s\n", j.Id, j.Created.Format("Jan 02 15:04"), done, failed, pending, j.Command, strings.Join(j.Args, " "))
+		}
+		return nil
+	},
+}
+
+var jobsShowCmd = &cobra.Command{
+	Use:   "show id",
+	Short: "Show the files of a job and their status",
+	Long: `Show the files of a job and their status.
+
+Only failed and pending files are shown unless --all is set.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		j, err := loadJob(args[0])
+		if err != nil {
+			return err
+		}
+
+		done, failed, pending := j.counts()
+		fmt.Printf("Job:     %s\n", j.Id)
+		fmt.Printf("Created: %s\n", j.Created.Format(time.RFC1123))
+		fmt.Printf("Command: stor %s %s\n", j.Command, strings.Join(j.Args, " "))
+		fmt.Printf("Files:   %d done, %d failed, %d pending\n\n", done, failed, pending)
+		for _, file := range j.Files {
+			if file.Status == statusDone && !jobsShowAll {
+				continue
+			}
+			fmt.Printf("%-7s %12d %s -> %s\n", file.Status, file.Size, file.Source, file.Target)
+		}
+		return nil
+	},
+}
+
+var jobsResumeCmd = &cobra.Command{
+	Use:   "resume id",
+	Short: "Copy the files of a job that are not done yet",
+	Long: `Copy the files of a job that are not done yet.
+
+Failed and pending files are copied again from the aliases of the original cp.
+Uploads reuse the blocks an interrupted attempt already put, as with cp --resume.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		start := time.Now()
+
+		j, err := loadJob(args[0])
+		if err != nil {
+			return err
+		}
+		sourceProvider, err := providers.Create(j.SourceAlias)
+		if err != nil {
+			return err
+		}
+		targetProvider, err := providers.Create(j.TargetAlias)
+		if err != nil {
+			return err
+		}
+		err = j.openProgress()
+		if err != nil {
+			return err
+		}
+
+		done, failed, pending := j.counts()
+		jww.INFO.Printf("Resuming job %s: %d done, %d failed, %d pending", j.Id, done, failed, pending)
+		err = runJob(j, sourceProvider, targetProvider, true)
+		jww.INFO.Printf("Elapsed: %v\n", time.Since(start))
+		return err
+	},
+}
+
+var jobsCleanCmd = &cobra.Command{
+	Use:   "clean [id...]",
+	Short: "Remove the journals of jobs",
+	Long: `Remove the journals of the given jobs or of every job when no id is given.
+The copied files and blobs are left as they are.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var jobs []*job
+		if len(args) == 0 {
+			all, err := listJobs()
+			if err != nil {
+				return err
+			}
+			jobs = all
+		}
+		for _, id := range args {
+			j, err := loadJob(id)
+			if err != nil {
+				return err
+			}
+			jobs = append(jobs, j)
+		}
+
+		for _, j := range jobs {
+			err := j.remove()
+			if err != nil {
+				return err
+			}
+			jww.INFO.Println("Removed job:", j.Id)
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(jobsCmd)
+	jobsCmd.AddCommand(jobsListCmd)
+	jobsCmd.AddCommand(jobsShowCmd)
+	jobsCmd.AddCommand(jobsResumeCmd)
+	jobsCmd.AddCommand(jobsCleanCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// jobsCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	jobsShowCmd.Flags().BoolVarP(&jobsShowAll, "all", "a", false, "include files that are done")
+}
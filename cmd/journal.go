@@ -0,0 +1,327 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hahutton/stor/providers"
+	homedir "github.com/mitchellh/go-homedir"
+	jww "github.com/spf13/jwalterweatherman"
+	"github.com/spf13/viper"
+)
+
+// A job is the on disk journal of one multi-file copy. plan.json holds every
+// planned file and is written once before anything is copied. progress holds
+// one line per finished file, appended as each one finishes, so a crash loses
+// at most the files in flight.
+type job struct {
+	Id          string     `json:"id"`
+	Created     time.Time  `json:"created"`
+	Command     string     `json:"command"`
+	Args        []string   `json:"args"`
+	SourceAlias string     `json:"sourceAlias"`
+	TargetAlias string     `json:"targetAlias"`
+	Files       []*jobFile `json:"files"`
+
+	dir      string
+	mu       sync.Mutex
+	progress *os.File
+}
+
+type jobFile struct {
+	Source  string    `json:"source"`
+	Target  string    `json:"target"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Status  string    `json:"-"`
+
+	//Set while planning so the first run need not stat every source again
+	info *providers.BlobInfo
+}
+
+type jobProgress struct {
+	Index  int       `json:"index"`
+	Status string    `json:"status"`
+	Time   time.Time `json:"time"`
+	Error  string    `json:"error,omitempty"`
+}
+
+const (
+	statusPending = "pending"
+	statusDone    = "done"
+	statusFailed  = "failed"
+)
+
+// jobsDir is the jobsDir config setting or ~/.stor/jobs.
+func jobsDir() (string, error) {
+	dir := viper.GetString("jobsDir")
+	if dir != "" {
+		return dir, nil
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".stor", "jobs"), nil
+}
+
+// newJob writes the plan for files to a new job directory and opens its progress log.
+// command and args are the stor command line the job was started with.
+func newJob(command string, args []string, sourceAlias string, targetAlias string, files []*jobFile) (*job, error) {
+	root, err := jobsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	j := &job{
+		Id:          fmt.Sprintf("%s-%d", now.Format("20060102-150405"), os.Getpid()),
+		Created:     now,
+		Command:     command,
+		Args:        args,
+		SourceAlias: sourceAlias,
+		TargetAlias: targetAlias,
+		Files:       files,
+	}
+	j.dir = filepath.Join(root, j.Id)
+	err = os.MkdirAll(j.dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (*job, error) {
+		os.RemoveAll(j.dir)
+		return nil, err
+	}
+
+	plan, err := json.Marshal(j)
+	if err != nil {
+		return fail(err)
+	}
+	//Written aside and renamed so a job never has a partial plan
+	err = ioutil.WriteFile(filepath.Join(j.dir, "plan.json.tmp"), plan, 0600)
+	if err != nil {
+		return fail(err)
+	}
+	err = os.Rename(filepath.Join(j.dir, "plan.json.tmp"), filepath.Join(j.dir, "plan.json"))
+	if err != nil {
+		return fail(err)
+	}
+
+	for _, file := range j.Files {
+		file.Status = statusPending
+	}
+	jww.INFO.Printf("Journal for job %s in %s", j.Id, j.dir)
+	err = j.openProgress()
+	if err != nil {
+		return fail(err)
+	}
+	return j, nil
+}
+
+// startJob is newJob or, when the journal can't be written, e.g. under a read
+// only $HOME, a job kept only in memory so the copy still goes ahead.
+func startJob(command string, args []string, sourceAlias string, targetAlias string, files []*jobFile) *job {
+	j, err := newJob(command, args, sourceAlias, targetAlias, files)
+	if err == nil {
+		return j
+	}
+	jww.WARN.Printf("Copying without a journal, so this %s can't be resumed: %v", command, err)
+
+	j = &job{Command: command, Args: args, SourceAlias: sourceAlias, TargetAlias: targetAlias, Files: files}
+	for _, file := range j.Files {
+		file.Status = statusPending
+	}
+	return j
+}
+
+// journaled reports whether the job is on disk and so can be resumed.
+func (j *job) journaled() bool {
+	return j.dir != ""
+}
+
+// loadJob reads a job's plan and replays its progress log into each file's Status.
+func loadJob(id string) (*job, error) {
+	root, err := jobsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(root, id)
+	plan, err := ioutil.ReadFile(filepath.Join(dir, "plan.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &providers.Error{Kind: providers.NotFound, Message: "no job " + id}
+		}
+		return nil, err
+	}
+
+	j := &job{dir: dir}
+	err = json.Unmarshal(plan, j)
+	if err != nil {
+		return nil, fmt.Errorf("job %s plan: %w", id, err)
+	}
+	if j.Command == "" {
+		j.Command = "cp"
+	}
+	for _, file := range j.Files {
+		file.Status = statusPending
+	}
+
+	progress, err := os.Open(filepath.Join(dir, "progress"))
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer progress.Close()
+
+	//A crash can leave the last line cut short. Anything unreadable is still pending.
+	scanner := bufio.NewScanner(progress)
+	for scanner.Scan() {
+		var entry jobProgress
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil || entry.Index < 0 || entry.Index >= len(j.Files) {
+			continue
+		}
+		j.Files[entry.Index].Status = entry.Status
+	}
+	return j, scanner.Err()
+}
+
+// listJobs loads every job sorted oldest first.
+func listJobs() ([]*job, error) {
+	root, err := jobsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*job
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		j, err := loadJob(entry.Name())
+		if err != nil {
+			jww.ERROR.Printf("Skipping job %s: %v", entry.Name(), err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].Created.Before(jobs[b].Created) })
+	return jobs, nil
+}
+
+// openProgress opens the progress log for appending. A line cut short by a
+// crash is ended first so the next entry isn't lost by being joined onto it.
+func (j *job) openProgress() error {
+	progress, err := os.OpenFile(filepath.Join(j.dir, "progress"), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := progress.Stat()
+	if err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		_, err = progress.ReadAt(last, info.Size()-1)
+		if err == nil && last[0] != '\n' {
+			_, err = progress.Write([]byte{'\n'})
+		}
+	}
+	if err != nil {
+		progress.Close()
+		return err
+	}
+	j.progress = progress
+	return nil
+}
+
+// record appends the outcome of file index to the progress log.
+func (j *job) record(index int, err error) {
+	entry := jobProgress{Index: index, Status: statusDone, Time: time.Now()}
+	if err != nil {
+		entry.Status = statusFailed
+		entry.Error = err.Error()
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Files[index].Status = entry.Status
+	if j.progress == nil {
+		return
+	}
+	line, _ := json.Marshal(entry)
+	_, writeErr := j.progress.Write(append(line, '\n'))
+	if writeErr != nil {
+		jww.ERROR.Printf("Could not journal %s: %v", j.Files[index].Source, writeErr)
+	}
+}
+
+// counts returns how many files are done, failed and pending.
+func (j *job) counts() (done int, failed int, pending int) {
+	for _, file := range j.Files {
+		switch file.Status {
+		case statusDone:
+			done++
+		case statusFailed:
+			failed++
+		default:
+			pending++
+		}
+	}
+	return done, failed, pending
+}
+
+func (j *job) close() {
+	if j.progress != nil {
+		j.progress.Close()
+		j.progress = nil
+	}
+}
+
+// remove deletes the job's journal.
+func (j *job) remove() error {
+	j.close()
+	if !j.journaled() {
+		return nil
+	}
+	return os.RemoveAll(j.dir)
+}
+
+// journalPath makes local names absolute so a job can be resumed from any directory.
+func journalPath(provider providers.Provider, name string) string {
+	if provider.ProviderName() != "file" {
+		return name
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+	return abs
+}
@@ -0,0 +1,145 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// writeJob makes job id under a temp jobsDir with a plan of files and the given
+// progress log.
+func writeJob(t *testing.T, id string, files int, progress string) {
+	t.Helper()
+	root, err := ioutil.TempDir("", "stor-jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("jobsDir", root)
+
+	plan := `{"id":"` + id + `","command":"cp","files":[`
+	for i := 0; i < files; i++ {
+		if i > 0 {
+			plan += ","
+		}
+		plan += `{"source":"s","target":"t","size":1}`
+	}
+	plan += "]}"
+
+	dir := filepath.Join(root, id)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "plan.json"), []byte(plan), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress != "" {
+		err = ioutil.WriteFile(filepath.Join(dir, "progress"), []byte(progress), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func cleanJobs() {
+	os.RemoveAll(viper.GetString("jobsDir"))
+	viper.Set("jobsDir", "")
+}
+
+func TestLoadJob(t *testing.T) {
+	tests := []struct {
+		progress string
+		want     []string
+	}{
+		{"", []string{statusPending, statusPending, statusPending}},
+		{
+			`{"index":0,"status":"done"}` + "\n" + `{"index":2,"status":"failed","error":"x"}` + "\n",
+			[]string{statusDone, statusPending, statusFailed},
+		},
+		// A crash cut the last line short
+		{
+			`{"index":0,"status":"done"}` + "\n" + `{"index":1,"sta`,
+			[]string{statusDone, statusPending, statusPending},
+		},
+		{
+			`{"index":0,"status":"done"}` + "\n" + `{"index":1,"status":"done"`,
+			[]string{statusDone, statusPending, statusPending},
+		},
+		// A later entry for the same file wins
+		{
+			`{"index":1,"status":"failed"}` + "\n" + `{"index":1,"status":"done"}` + "\n",
+			[]string{statusPending, statusDone, statusPending},
+		},
+		{
+			`{"index":3,"status":"done"}` + "\n" + `{"index":-1,"status":"done"}` + "\n" + "garbage\n",
+			[]string{statusPending, statusPending, statusPending},
+		},
+	}
+	for _, test := range tests {
+		writeJob(t, "job", 3, test.progress)
+		j, err := loadJob("job")
+		cleanJobs()
+		if err != nil {
+			t.Errorf("progress %q: %v", test.progress, err)
+			continue
+		}
+		for i, file := range j.Files {
+			if file.Status != test.want[i] {
+				t.Errorf("progress %q: file %d is %s, want %s", test.progress, i, file.Status, test.want[i])
+			}
+		}
+	}
+}
+
+func TestLoadJobNotFound(t *testing.T) {
+	writeJob(t, "job", 1, "")
+	defer cleanJobs()
+	_, err := loadJob("other")
+	if err == nil {
+		t.Error("loaded a job that doesn't exist")
+	}
+}
+
+// Resuming after a truncated final line must not lose the next entry to it.
+func TestResumeAfterTruncatedProgress(t *testing.T) {
+	writeJob(t, "job", 3, `{"index":0,"status":"done"}`+"\n"+`{"index":1,"sta`)
+	defer cleanJobs()
+
+	j, err := loadJob("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = j.openProgress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.record(1, nil)
+	j.record(2, nil)
+	j.close()
+
+	j, err = loadJob("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, failed, pending := j.counts()
+	if done != 3 || failed != 0 || pending != 0 {
+		t.Errorf("got %d done, %d failed and %d pending, want all 3 done", done, failed, pending)
+	}
+}
@@ -20,39 +20,59 @@ import (
 	"github.com/hahutton/stor/providers"
 	"github.com/spf13/cobra"
 	jww "github.com/spf13/jwalterweatherman"
+	"github.com/spf13/viper"
 )
 
 var Long bool
 var NoHeader bool
+var MaxResults int
 
 // lsCmd represents the ls command
 var lsCmd = &cobra.Command{
 	Use:   "ls [//alias/]source_name [flags]",
 	Short: "List blobs",
-	Long:  `List blobs.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `List blobs.
+
+The source_name is matched as a prefix of the blob names, so //alias/logs/2024
+lists every blob beginning with logs/2024. Shell style wildcards (*, ? and [...])
+may follow the prefix, e.g. '//alias/logs/2024-*.csv', and are matched with the
+same rules as cp.
+
+Results are printed as each page of the listing arrives. Large containers are
+listed completely by following the continuation marker from page to page.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		start := time.Now()
 
-		sourceAlias, sourcePathName := providers.Parse(args[0])
+		sourceAlias, sourcePathName, err := providers.Parse(args[0])
+		if err != nil {
+			return err
+		}
 
-		sourceProvider := providers.Create(sourceAlias)
-		sourceInfos := sourceProvider.Glob(sourcePathName)
+		sourceProvider, err := providers.Create(sourceAlias)
+		if err != nil {
+			return err
+		}
 
 		if !NoHeader {
 			fmt.Printf("%s\n", sourcePathName)
 		}
 
 		var layout string = "Jan 02 15:04"
-		for _, si := range sourceInfos {
+		err = sourceProvider.Walk(sourcePathName, func(si *providers.BlobInfo) error {
 			if Long {
 				fmt.Printf("%s  %s %s %10d %s\n", si.BlobType, si.LastModified.Format(layout), si.Etag, si.Length, si.Name)
 			} else {
 				fmt.Println(si.Name)
 			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 
 		duration := time.Since(start)
 		jww.INFO.Printf("Elapsed: %v\n", duration)
+		return nil
 	},
 }
 
@@ -69,4 +89,6 @@ func init() {
 	// is called directly, e.g.:
 	lsCmd.Flags().BoolVarP(&Long, "long", "l", false, "included extended attributes")
 	lsCmd.Flags().BoolVarP(&NoHeader, "noheader", "n", false, "remove header from output")
+	lsCmd.Flags().IntVarP(&MaxResults, "maxresults", "m", 0, "blobs fetched per page of the listing (default is the provider max of 5000)")
+	viper.BindPFlag("maxresults", lsCmd.Flags().Lookup("maxresults"))
 }
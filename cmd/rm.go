@@ -0,0 +1,247 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hahutton/stor/providers"
+	"github.com/spf13/cobra"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+var rmRecurse bool
+var rmDryRun bool
+var rmForce bool
+var rmConfirmOver int
+
+type removal struct {
+	provider providers.Provider
+	alias    string
+	name     string
+}
+
+func (r removal) String() string {
+	if r.alias == "file" {
+		return r.name
+	}
+	return fmt.Sprintf("//%s/%s", r.alias, strings.TrimPrefix(r.name, "/"))
+}
+
+// rmCmd represents the rm command
+var rmCmd = &cobra.Command{
+	Use:   "rm [//alias/]name...",
+	Short: "Remove blobs or local files",
+	Long: `Remove blobs from cloud storage and/or files from the local filesystem.
+
+Without -R each name must match a blob or file exactly. Shell style wildcards
+(*, ? and [...]) are matched the same way as ls and cp, so quote them when the
+target is an object store.
+
+With -R object store names are treated as prefixes and every blob beginning
+with the name is removed. Local directories are removed with their contents.
+
+When more than --confirm-over objects match, rm asks before removing anything
+unless --force is set. --dry-run prints the matches without removing them.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		start := time.Now()
+
+		var removals []removal
+		for _, arg := range args {
+			alias, pathName, err := providers.Parse(arg)
+			if err != nil {
+				return err
+			}
+			jww.INFO.Printf("alias: %s, pathName: %s", alias, pathName)
+			provider, err := providers.Create(alias)
+			if err != nil {
+				return err
+			}
+
+			matches, err := matchRemovals(provider, alias, pathName)
+			if err != nil {
+				return err
+			}
+			if len(matches) == 0 {
+				return &providers.Error{Kind: providers.NotFound, Message: "no match for " + arg}
+			}
+			removals = append(removals, matches...)
+		}
+
+		if rmDryRun {
+			for _, r := range removals {
+				fmt.Println(r)
+			}
+			return nil
+		}
+
+		if len(removals) > rmConfirmOver && !rmForce && !confirm(fmt.Sprintf("Remove %d objects?", len(removals))) {
+			return errors.New("nothing removed")
+		}
+
+		//Local directories have to be empty before they can go so those are removed last
+		var dirs []removal
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var failures int
+		var firstErr error
+		failed := func(r removal, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			jww.ERROR.Println("Bad remove:", r)
+			jww.ERROR.Println(err)
+			failures++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		tokenBucket := providers.InitTokenBucket()
+		for _, r := range removals {
+			if r.alias == "file" && isLocalDir(r.name) {
+				dirs = append(dirs, r)
+				continue
+			}
+			token := <-tokenBucket
+			wg.Add(1)
+			go func(r removal, token int) {
+				defer func() { tokenBucket <- token }()
+				defer wg.Done()
+				err := r.provider.Delete(r.name)
+				if err != nil {
+					failed(r, err)
+					return
+				}
+				jww.INFO.Println("Removed:", r)
+			}(r, token)
+		}
+		wg.Wait()
+
+		for i := len(dirs) - 1; i >= 0; i-- {
+			err := dirs[i].provider.Delete(dirs[i].name)
+			if err != nil {
+				failed(dirs[i], err)
+			}
+		}
+
+		duration := time.Since(start)
+		jww.INFO.Printf("Elapsed: %v\n", duration)
+		if firstErr != nil {
+			return fmt.Errorf("%d of %d removals failed. First failure: %w", failures, len(removals), firstErr)
+		}
+		return nil
+	},
+}
+
+// matchRemovals expands pathName into the objects rm would remove from provider.
+func matchRemovals(provider providers.Provider, alias string, pathName string) ([]removal, error) {
+	var removals []removal
+
+	//A plain blob name is that blob alone, not every blob it is a prefix of
+	if provider.ProviderName() != "file" && !rmRecurse && !strings.ContainsAny(pathName, "*?[\\") {
+		info, err := provider.Stat(pathName)
+		if providers.KindOf(err) == providers.NotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir {
+			jww.ERROR.Printf("%s is a virtual directory. Use -R to remove it.", pathName)
+			return nil, nil
+		}
+		return []removal{{provider, alias, info.PathName}}, nil
+	}
+
+	matches, err := provider.Glob(pathName)
+	if err != nil {
+		return nil, err
+	}
+
+	if provider.ProviderName() == "file" {
+		for _, info := range matches {
+			if !info.IsDir {
+				removals = append(removals, removal{provider, alias, info.PathName})
+				continue
+			}
+			if !rmRecurse {
+				jww.ERROR.Printf("%s is a directory. Use -R to remove it.", info.PathName)
+				continue
+			}
+			//Walk visits parents before children which is the order removals are listed in
+			err := filepath.Walk(info.PathName, func(walkPath string, fileInfo os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				removals = append(removals, removal{provider, alias, walkPath})
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return removals, nil
+	}
+
+	//Blob stores have no directories so -R widens a name to a prefix
+	pattern := strings.TrimPrefix(pathName, "/")
+	for _, info := range matches {
+		if !rmRecurse {
+			matched, _ := path.Match(pattern, info.Name)
+			if !matched {
+				continue
+			}
+		}
+		removals = append(removals, removal{provider, alias, info.PathName})
+	}
+	return removals, nil
+}
+
+func isLocalDir(name string) bool {
+	fileInfo, err := os.Lstat(name)
+	return err == nil && fileInfo.IsDir()
+}
+
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func init() {
+	RootCmd.AddCommand(rmCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// rmCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	rmCmd.Flags().BoolVarP(&rmRecurse, "Recurse", "R", false, "Remove by prefix for object stores and directories for the local file provider")
+	rmCmd.Flags().BoolVarP(&rmDryRun, "dry-run", "d", false, "show set of blobs to be removed but don't remove")
+	rmCmd.Flags().BoolVarP(&rmForce, "force", "f", false, "don't ask for confirmation")
+	rmCmd.Flags().IntVar(&rmConfirmOver, "confirm-over", 10, "ask for confirmation when more than this many objects match")
+}
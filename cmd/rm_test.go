@@ -0,0 +1,115 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hahutton/stor/providers"
+)
+
+// blobServer answers Get Blob Properties and List Blobs for names in cont and
+// records the prefix of every listing.
+type blobServer struct {
+	*httptest.Server
+	names    []string
+	mu       sync.Mutex
+	prefixes []string
+}
+
+func newBlobServer(names ...string) *blobServer {
+	server := &blobServer{names: names}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/acct/cont/")
+		switch {
+		case r.Method == "HEAD":
+			for _, known := range server.names {
+				if known == name {
+					w.Header().Set("x-ms-blob-type", "BlockBlob")
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Query().Get("comp") == "list":
+			prefix := r.URL.Query().Get("prefix")
+			server.mu.Lock()
+			server.prefixes = append(server.prefixes, prefix)
+			server.mu.Unlock()
+			results := providers.EnumerationResults{Prefix: prefix}
+			for _, known := range server.names {
+				if strings.HasPrefix(known, prefix) {
+					results.Blobs = append(results.Blobs, providers.Blob{Name: known, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT", BlobType: "BlockBlob"})
+				}
+			}
+			body, _ := xml.Marshal(results)
+			w.Write(body)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	return server
+}
+
+func TestMatchRemovals(t *testing.T) {
+	server := newBlobServer("logs/a.txt", "logs/a.txt.bak", "logs/b/c.txt")
+	defer server.Close()
+	provider := &providers.AzureProvider{
+		AccountName:   "acct",
+		ContainerName: "cont",
+		SAS:           "sv=2017-11-09&sig=test",
+		Endpoint:      server.URL + "/acct",
+	}
+	defer func(recurse bool) { rmRecurse = recurse }(rmRecurse)
+
+	tests := []struct {
+		pathName string
+		recurse  bool
+		want     []string
+		prefixes []string
+	}{
+		{"logs/a.txt", false, []string{"logs/a.txt"}, nil},
+		{"/logs/a.txt", false, []string{"logs/a.txt"}, nil},
+		{"logs/missing", false, nil, []string{"logs/missing/"}},
+		{"logs/b", false, nil, []string{"logs/b/"}},
+		{"logs/a.txt*", false, []string{"logs/a.txt", "logs/a.txt.bak"}, []string{"logs/a.txt"}},
+		{"logs/a.txt", true, []string{"logs/a.txt", "logs/a.txt.bak"}, []string{"logs/a.txt"}},
+	}
+	for _, test := range tests {
+		server.prefixes = nil
+		rmRecurse = test.recurse
+		removals, err := matchRemovals(provider, "blobs", test.pathName)
+		if err != nil {
+			t.Fatalf("matchRemovals(%q) recurse %v: %v", test.pathName, test.recurse, err)
+		}
+		var names []string
+		for _, r := range removals {
+			names = append(names, strings.TrimPrefix(r.name, "/"))
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, test.want) {
+			t.Errorf("matchRemovals(%q) recurse %v = %q, want %q", test.pathName, test.recurse, names, test.want)
+		}
+		if !reflect.DeepEqual(server.prefixes, test.prefixes) {
+			t.Errorf("matchRemovals(%q) recurse %v listed %q, want %q", test.pathName, test.recurse, server.prefixes, test.prefixes)
+		}
+	}
+}
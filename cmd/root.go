@@ -14,8 +14,10 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
 
+	"github.com/hahutton/stor/providers"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	jww "github.com/spf13/jwalterweatherman"
@@ -32,15 +34,75 @@ var RootCmd = &cobra.Command{
 	Long: `stor is a cli tool to interact with azure storage.
 While stor aims to be a sharp tool with a more unix philosophy,
 azcopy should be used whenever possible due to its robustness and
-feature set. stor aims to have no dependencies which is a difference.`,
+feature set. stor aims to have no dependencies which is a difference.
+
+Exit codes:
+  0  success
+  1  general failure
+  2  invalid config or alias
+  3  blob or file not found
+  4  authentication or authorization failed
+  5  throttled by the provider
+  6  conflict with the current state of the blob`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
 }
 
+// Exit codes returned by stor. Each provider error Kind has its own.
+const (
+	exitFailure       = 1
+	exitInvalidConfig = 2
+	exitNotFound      = 3
+	exitAuthFailed    = 4
+	exitThrottled     = 5
+	exitConflict      = 6
+)
+
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	if stdoutIsData(os.Args[1:]) {
+		jww.SetStdoutOutput(os.Stderr)
+	}
 	if err := RootCmd.Execute(); err != nil {
 		jww.ERROR.Println(err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+func exitCode(err error) int {
+	switch providers.KindOf(err) {
+	case providers.InvalidConfig:
+		return exitInvalidConfig
+	case providers.NotFound:
+		return exitNotFound
+	case providers.AuthFailed:
+		return exitAuthFailed
+	case providers.Throttled:
+		return exitThrottled
+	case providers.Conflict:
+		return exitConflict
 	}
+	return exitFailure
+}
+
+// stdoutIsData reports whether stdout may carry a blob, as with stor cat, head and
+// tail or 'stor cp //alias/blob -', in which case logging goes to stderr. It has
+// to be known before the config is read.
+func stdoutIsData(args []string) bool {
+	if cmd, _, err := RootCmd.Find(args); err == nil && (cmd == catCmd || cmd == headCmd || cmd == tailCmd) {
+		return true
+	}
+	for _, arg := range args {
+		if arg == providers.STDIO {
+			return true
+		}
+	}
+	return false
+}
+
+// configError is an InvalidConfig error for arguments a command can't work with.
+func configError(format string, args ...interface{}) error {
+	return &providers.Error{Kind: providers.InvalidConfig, Message: fmt.Sprintf(format, args...)}
 }
 
 func init() {
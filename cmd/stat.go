@@ -0,0 +1,143 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/hahutton/stor/providers"
+	"github.com/spf13/cobra"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+var statJson bool
+
+// statCmd represents the stat command
+var statCmd = &cobra.Command{
+	Use:   "stat [//alias/]name...",
+	Short: "Show all properties of blobs or local files",
+	Long: `Show all properties of blobs or local files.
+
+stat prints the size, etag, MD5, content type and encoding, access tier,
+lease state, metadata and times the provider knows for each name. A name that
+is a prefix of other blobs is reported as a virtual directory.
+
+--json prints an array with one object per name instead.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		//Empty rather than nil so --json prints [] when every name fails
+		infos := []*providers.BlobInfo{}
+		var failures int
+		var firstErr error
+		for _, arg := range args {
+			alias, pathName, err := providers.Parse(arg)
+			if err != nil {
+				return err
+			}
+			provider, err := providers.Create(alias)
+			if err != nil {
+				return err
+			}
+
+			info, err := provider.Stat(pathName)
+			if err != nil {
+				jww.ERROR.Printf("Bad stat of %s: %v", arg, err)
+				failures++
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			infos = append(infos, info)
+		}
+
+		if statJson {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			err := encoder.Encode(infos)
+			if err != nil {
+				return err
+			}
+		} else {
+			for i, info := range infos {
+				if i > 0 {
+					fmt.Println()
+				}
+				printStat(info)
+			}
+		}
+
+		if firstErr != nil {
+			return fmt.Errorf("%d of %d names failed. First failure: %w", failures, len(args), firstErr)
+		}
+		return nil
+	},
+}
+
+func printStat(info *providers.BlobInfo) {
+	field := func(name string, value string) {
+		if value != "" {
+			fmt.Printf("%18s: %s\n", name, value)
+		}
+	}
+	timeField := func(name string, value time.Time) {
+		if !value.IsZero() {
+			field(name, value.Format(time.RFC1123))
+		}
+	}
+
+	field("Name", info.PathName)
+	if info.IsDir {
+		field("Type", "Directory")
+		return
+	}
+	field("Type", info.BlobType)
+	fmt.Printf("%18s: %d\n", "Size", info.Length)
+	field("Etag", info.Etag)
+	field("Content-MD5", info.MD5)
+	field("Content-Type", info.Type)
+	field("Content-Encoding", info.Encoding)
+	field("Access-Tier", info.AccessTier)
+	field("Lease-State", info.LeaseState)
+	field("Lease-Status", info.LeaseStatus)
+	timeField("Created", info.CreatedAt)
+	timeField("Last-Modified", info.LastModified)
+
+	keys := make([]string, 0, len(info.Metadata))
+	for key := range info.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field("Metadata", fmt.Sprintf("%s=%s", key, info.Metadata[key]))
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(statCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// statCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	statCmd.Flags().BoolVarP(&statJson, "json", "j", false, "print properties as json")
+}
@@ -0,0 +1,54 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestStatJsonAllFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stor-stat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("aliases.file.provider", "file")
+	defer viper.Set("aliases.file.provider", "")
+	defer func(json bool) { statJson = json }(statJson)
+	statJson = true
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	err = statCmd.RunE(statCmd, []string{filepath.Join(dir, "missing")})
+	os.Stdout = stdout
+	writer.Close()
+	out, _ := ioutil.ReadAll(reader)
+
+	if err == nil {
+		t.Error("stat of a missing file succeeded")
+	}
+	if strings.TrimSpace(string(out)) != "[]" {
+		t.Errorf("--json printed %q, want []", out)
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hahutton/stor/providers"
+	"github.com/spf13/cobra"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+var syncChecksum bool
+var syncDelete bool
+var syncDryRun bool
+
+// A syncAction is what sync does with one relative name and why.
+type syncAction struct {
+	action string
+	name   string
+	reason string
+	source *providers.BlobInfo
+	target *providers.BlobInfo
+}
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync [//alias/]source [//alias/]target",
+	Short: "Mirror a directory or prefix one way between providers",
+	Long: `Mirror a local directory to a blob prefix or a blob prefix to a local directory.
+
+Both sides are listed and each source file or blob is copied to the same
+relative name under the target when the target is missing it or it differs.
+By default it differs when the lengths differ or the source was modified after
+the target. With --checksum lengths and MD5s are compared instead, which reads
+every local file whose length matches.
+
+--delete removes target objects that are not in the source once every copy has
+succeeded. --dry-run prints each planned upload, download, skip and delete with
+the reason for it.
+
+Copies are journaled like cp so a failed sync can be continued with stor jobs resume.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		start := time.Now()
+
+		sourceAlias, sourcePathName, err := providers.Parse(args[0])
+		if err != nil {
+			return err
+		}
+		targetAlias, targetPathName, err := providers.Parse(args[1])
+		if err != nil {
+			return err
+		}
+		sourceProvider, err := providers.Create(sourceAlias)
+		if err != nil {
+			return err
+		}
+		targetProvider, err := providers.Create(targetAlias)
+		if err != nil {
+			return err
+		}
+
+		verb := "upload"
+		if sourceProvider.ProviderName() == "azure" && targetProvider.ProviderName() == "file" {
+			verb = "download"
+		} else if sourceProvider.ProviderName() != "file" || targetProvider.ProviderName() != "azure" {
+			return errors.New("sync currently implements file to azure and azure to file only")
+		}
+
+		sourceListing, err := syncListing(sourceProvider, sourcePathName)
+		if err != nil {
+			return err
+		}
+		if len(sourceListing) == 0 {
+			return &providers.Error{Kind: providers.NotFound, Message: "nothing to sync in " + args[0]}
+		}
+		targetListing, err := syncListing(targetProvider, targetPathName)
+		if err != nil {
+			return err
+		}
+
+		actions, err := planSync(verb, sourceListing, targetListing)
+		if err != nil {
+			return err
+		}
+
+		if syncDryRun {
+			for _, a := range actions {
+				fmt.Printf("%-8s %s (%s)\n", a.action, a.name, a.reason)
+			}
+			return nil
+		}
+
+		var files []*jobFile
+		var deletes []*syncAction
+		for _, a := range actions {
+			switch a.action {
+			case verb:
+				targetName, err := syncTarget(targetProvider, targetPathName, a.name)
+				if err != nil {
+					return err
+				}
+				files = append(files, &jobFile{
+					Source:  journalPath(sourceProvider, a.source.PathName),
+					Target:  journalPath(targetProvider, targetName),
+					Size:    a.source.Length,
+					ModTime: a.source.LastModified,
+					info:    a.source,
+				})
+			case "delete":
+				if targetProvider.ProviderName() == "file" && !underRoot(targetPathName, a.target.PathName) {
+					return configError("%s is outside %s and won't be deleted", a.target.PathName, targetPathName)
+				}
+				deletes = append(deletes, a)
+			default:
+				jww.INFO.Printf("skip %s (%s)", a.name, a.reason)
+			}
+		}
+		jww.INFO.Printf("%d to %s, %d to delete, %d skipped", len(files), verb, len(deletes), len(actions)-len(files)-len(deletes))
+
+		if len(files) > 0 {
+			j := startJob("sync", args, sourceAlias, targetAlias, files)
+			err = runJob(j, sourceProvider, targetProvider, false)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = syncDeletes(targetProvider, deletes)
+		jww.INFO.Printf("Elapsed: %v\n", time.Since(start))
+		return err
+	},
+}
+
+// syncListing lists every file or blob under root keyed by its name relative
+// to root with / separators. A root that does not exist has nothing under it.
+func syncListing(provider providers.Provider, root string) (map[string]*providers.BlobInfo, error) {
+	listing := make(map[string]*providers.BlobInfo)
+
+	if provider.ProviderName() != "file" {
+		prefix := blobKey(root)
+		if prefix != "" && !isDir(prefix) {
+			prefix += "/"
+		}
+		err := provider.Walk("/"+prefix, func(blobInfo *providers.BlobInfo) error {
+			listing[strings.TrimPrefix(blobKey(blobInfo.PathName), prefix)] = blobInfo
+			return nil
+		})
+		return listing, err
+	}
+
+	rootInfo, err := provider.Stat(root)
+	if providers.KindOf(err) == providers.NotFound {
+		return listing, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !rootInfo.IsDir {
+		listing[rootInfo.Name] = rootInfo
+		return listing, nil
+	}
+
+	infos, err := localSources(provider, root, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		rel, err := filepath.Rel(root, info.PathName)
+		if err != nil {
+			return nil, err
+		}
+		listing[filepath.ToSlash(rel)] = info
+	}
+	return listing, nil
+}
+
+// planSync decides what happens to each name in either listing, sorted by name.
+func planSync(verb string, sourceListing map[string]*providers.BlobInfo, targetListing map[string]*providers.BlobInfo) ([]*syncAction, error) {
+	var actions []*syncAction
+	var checksums []*syncAction
+	for name, source := range sourceListing {
+		a := &syncAction{action: verb, name: name, source: source, target: targetListing[name]}
+		actions = append(actions, a)
+		switch {
+		case a.target == nil:
+			a.reason = "missing from target"
+		case source.Length != a.target.Length:
+			a.reason = fmt.Sprintf("length %d differs from %d", source.Length, a.target.Length)
+		case syncChecksum:
+			checksums = append(checksums, a)
+		case source.LastModified.After(a.target.LastModified):
+			a.reason = "source is newer"
+		default:
+			a.action = "skip"
+			a.reason = "same length and target is not older"
+		}
+	}
+
+	if syncDelete {
+		for name, target := range targetListing {
+			if _, ok := sourceListing[name]; !ok {
+				actions = append(actions, &syncAction{action: "delete", name: name, reason: "not in source", target: target})
+			}
+		}
+	}
+
+	//Only the local side of a pair needs reading. Blobs have their MD5 listed.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	tokenBucket := providers.InitTokenBucket()
+	for _, a := range checksums {
+		token := <-tokenBucket
+		wg.Add(1)
+		go func(a *syncAction, token int) {
+			defer func() { tokenBucket <- token }()
+			defer wg.Done()
+			sourceMD5, targetMD5, err := syncMD5s(a)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				if firstErr == nil {
+					firstErr = err
+				}
+			case sourceMD5 == "" || targetMD5 == "":
+				a.reason = "no MD5 to compare"
+			case sourceMD5 != targetMD5:
+				a.reason = "MD5 differs"
+			default:
+				a.action = "skip"
+				a.reason = "same MD5"
+			}
+		}(a, token)
+	}
+	wg.Wait()
+
+	sort.Slice(actions, func(i, j int) bool { return actions[i].name < actions[j].name })
+	return actions, firstErr
+}
+
+// syncMD5s is the base64 MD5 of both sides of a pair. Local files are read
+// and blobs report their Content-MD5, which is empty when they have none.
+func syncMD5s(a *syncAction) (string, string, error) {
+	md5s := make([]string, 2)
+	for i, info := range []*providers.BlobInfo{a.source, a.target} {
+		if info.BlobType != "FileSystem" {
+			md5s[i] = info.MD5
+			continue
+		}
+		localMD5, err := fileMD5(info.PathName)
+		if err != nil {
+			return "", "", err
+		}
+		md5s[i] = localMD5
+	}
+	return md5s[0], md5s[1], nil
+}
+
+// syncTarget is where name goes under the target root. A blob name whose ..
+// segments would take it outside a local root is an error.
+func syncTarget(provider providers.Provider, root string, name string) (string, error) {
+	if provider.ProviderName() == "file" {
+		targetName := filepath.Join(root, filepath.FromSlash(name))
+		if !underRoot(root, targetName) {
+			return "", configError("%s would be synced to %s, outside %s", name, targetName, root)
+		}
+		return targetName, nil
+	}
+	prefix := blobKey(root)
+	if prefix != "" && !isDir(prefix) {
+		prefix += "/"
+	}
+	return "/" + prefix + name, nil
+}
+
+// syncDeletes removes the extraneous target objects in parallel.
+func syncDeletes(provider providers.Provider, deletes []*syncAction) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failures int
+	var firstErr error
+
+	tokenBucket := providers.InitTokenBucket()
+	for _, a := range deletes {
+		token := <-tokenBucket
+		wg.Add(1)
+		go func(a *syncAction, token int) {
+			defer func() { tokenBucket <- token }()
+			defer wg.Done()
+			err := provider.Delete(a.target.PathName)
+			if err == nil {
+				jww.INFO.Println("Removed:", a.target.PathName)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			jww.ERROR.Printf("Bad remove of %s: %v", a.target.PathName, err)
+			failures++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}(a, token)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return fmt.Errorf("%d of %d deletes failed. First failure: %w", failures, len(deletes), firstErr)
+	}
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(syncCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// syncCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	syncCmd.Flags().BoolVarP(&syncChecksum, "checksum", "c", false, "compare MD5s instead of modification times")
+	syncCmd.Flags().BoolVar(&syncDelete, "delete", false, "delete target objects that are not in the source")
+	syncCmd.Flags().BoolVarP(&syncDryRun, "dry-run", "d", false, "show the planned actions and why without doing them")
+}
@@ -0,0 +1,188 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hahutton/stor/providers"
+	"github.com/spf13/viper"
+)
+
+// writeFile writes data to name under dir with the given modification time.
+func writeFile(t *testing.T, dir string, name string, data string, modTime time.Time) {
+	t.Helper()
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(path, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlanSyncAndDelete(t *testing.T) {
+	source, err := ioutil.TempDir("", "stor-sync-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(source)
+	target, err := ioutil.TempDir("", "stor-sync-target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(target)
+
+	old := time.Now().Add(-time.Hour)
+	now := time.Now()
+	writeFile(t, source, "same.txt", "same", old)
+	writeFile(t, target, "same.txt", "same", now)
+	writeFile(t, source, "logs/new.txt", "new", now)
+	writeFile(t, source, "length.txt", "longer", old)
+	writeFile(t, target, "length.txt", "short", now)
+	writeFile(t, source, "newer.txt", "newer", now)
+	writeFile(t, target, "newer.txt", "older", old)
+	writeFile(t, target, "logs/extra.txt", "extra", now)
+
+	provider := &providers.FileProvider{}
+	sourceListing, err := syncListing(provider, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetListing, err := syncListing(provider, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	syncDelete = true
+	defer func() { syncDelete = false }()
+	actions, err := planSync("upload", sourceListing, targetListing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct{ name, action string }{
+		{"length.txt", "upload"},
+		{"logs/extra.txt", "delete"},
+		{"logs/new.txt", "upload"},
+		{"newer.txt", "upload"},
+		{"same.txt", "skip"},
+	}
+	if len(actions) != len(want) {
+		t.Fatalf("got %d actions, want %d", len(actions), len(want))
+	}
+	var deletes []*syncAction
+	for i, a := range actions {
+		if a.name != want[i].name || a.action != want[i].action {
+			t.Errorf("action %d is %s %s (%s), want %s %s", i, a.action, a.name, a.reason, want[i].action, want[i].name)
+		}
+		if a.action == "delete" {
+			deletes = append(deletes, a)
+		}
+	}
+
+	jobs, err := ioutil.TempDir("", "stor-jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(jobs)
+	viper.Set("jobsDir", jobs)
+	defer viper.Set("jobsDir", "")
+
+	var files []*jobFile
+	for _, a := range actions {
+		if a.action != "upload" {
+			continue
+		}
+		targetName, err := syncTarget(provider, target, a.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, &jobFile{Source: a.source.PathName, Target: targetName, info: a.source})
+	}
+	err = runJob(startJob("sync", nil, "", "", files), provider, provider, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"length.txt", "logs/new.txt", "newer.txt", "same.txt"} {
+		sourceData, _ := ioutil.ReadFile(filepath.Join(source, filepath.FromSlash(name)))
+		targetData, err := ioutil.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+		if err != nil || string(targetData) != string(sourceData) {
+			t.Errorf("%s in the target is %q, %v, want %q", name, targetData, err, sourceData)
+		}
+	}
+
+	err = syncDeletes(provider, deletes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "logs", "extra.txt")); !os.IsNotExist(err) {
+		t.Errorf("logs/extra.txt is still in the target: %v", err)
+	}
+	for _, name := range []string{"same.txt", "length.txt", "newer.txt"} {
+		if _, err := os.Stat(filepath.Join(target, name)); err != nil {
+			t.Errorf("%s was deleted from the target: %v", name, err)
+		}
+	}
+}
+
+// Without --delete nothing in the target alone is touched.
+func TestPlanSyncWithoutDelete(t *testing.T) {
+	sourceListing := map[string]*providers.BlobInfo{}
+	targetListing := map[string]*providers.BlobInfo{"extra.txt": {PathName: "extra.txt"}}
+	actions, err := planSync("download", sourceListing, targetListing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 0 {
+		t.Errorf("got %d actions, want none", len(actions))
+	}
+}
+
+func TestSyncTarget(t *testing.T) {
+	provider := &providers.FileProvider{}
+	tests := []struct {
+		root, name string
+		want       string
+		wantErr    bool
+	}{
+		{"out", "a.txt", filepath.Join("out", "a.txt"), false},
+		{"out/", "logs/a.txt", filepath.Join("out", "logs", "a.txt"), false},
+		{"out", "logs/../a.txt", filepath.Join("out", "a.txt"), false},
+		{"out", "../a.txt", "", true},
+		{"out", "logs/../../../etc/passwd", "", true},
+	}
+	for _, test := range tests {
+		got, err := syncTarget(provider, test.root, test.name)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("syncTarget(%q, %q) = %q, want an error", test.root, test.name, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("syncTarget(%q, %q) = %q, %v, want %q", test.root, test.name, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+
+	"github.com/hahutton/stor/providers"
+	"github.com/spf13/cobra"
+)
+
+var tailBytes int64
+var tailLines int
+
+// tailCmd represents the tail command
+var tailCmd = &cobra.Command{
+	Use:   "tail [//alias/]name...",
+	Short: "Print the last bytes or lines of blobs or local files",
+	Long: `Print the last 10 lines of each blob or local file to stdout.
+
+-n N prints the last N lines and -c N the last N bytes. The length of the blob
+comes from its properties and only its end is fetched with ranged GETs. Blocks
+are read one at a time back from the end, counting newlines, until the start of
+the lines is found and they are then printed as -c does, so memory use doesn't
+grow with their length.
+
+With more than one name each is preceded by a ==> name <== header like tail(1).`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if tailBytes < 0 || tailLines < 0 {
+			return configError("tail needs a count of 0 or more")
+		}
+		byBytes := cmd.Flags().Changed("bytes")
+
+		tokenBucket := providers.InitTokenBucket()
+		for i, arg := range args {
+			provider, pathName, info, err := partialSource(arg)
+			if err != nil {
+				return err
+			}
+			if len(args) > 1 {
+				printHeader(i, arg)
+			}
+
+			if byBytes {
+				start := info.Length - tailBytes
+				if start < 0 {
+					start = 0
+				}
+				err = writeRange(provider, pathName, start, info.Length-start, tokenBucket)
+			} else {
+				err = tailLinesOf(provider, pathName, info.Length, tailLines, tokenBucket)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// tailLinesOf prints the last lines of name, which is length bytes long.
+func tailLinesOf(provider providers.Provider, name string, length int64, lines int, tokenBucket chan int) error {
+	if lines == 0 {
+		return nil
+	}
+	start, err := tailStart(provider, name, length, lines, tokenBucket)
+	if err != nil {
+		return err
+	}
+	return writeRange(provider, name, start, length-start, tokenBucket)
+}
+
+// tailStart is the offset where the last lines lines of name begin. Blocks are
+// read back from the end one at a time and only their newlines are counted. A
+// newline ending the blob ends its last line rather than starting another.
+func tailStart(provider providers.Provider, name string, length int64, lines int, tokenBucket chan int) (int64, error) {
+	blockSize := int64(providers.BlockSize())
+	for offset := length; offset > 0; {
+		chunk := blockSize
+		if chunk > offset {
+			chunk = offset
+		}
+		offset -= chunk
+		data, err := readBlockAt(provider, name, offset, chunk, tokenBucket)
+		if err != nil {
+			return 0, err
+		}
+
+		end := len(data)
+		if offset+int64(end) == length && end > 0 && data[end-1] == '\n' {
+			end--
+		}
+		for ; lines > 0; lines-- {
+			newline := bytes.LastIndexByte(data[:end], '\n')
+			if newline < 0 {
+				break
+			}
+			end = newline
+		}
+		providers.PutBuffer(data)
+		if lines == 0 {
+			return offset + int64(end) + 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func init() {
+	RootCmd.AddCommand(tailCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// tailCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	tailCmd.Flags().Int64VarP(&tailBytes, "bytes", "c", 0, "print the last N bytes")
+	tailCmd.Flags().IntVarP(&tailLines, "lines", "n", 10, "print the last N lines")
+}
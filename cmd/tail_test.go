@@ -0,0 +1,77 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hahutton/stor/providers"
+)
+
+func TestTailStart(t *testing.T) {
+	long := strings.Repeat("x", 5999) + "\n"
+	tests := []struct {
+		data  string
+		lines int
+		want  int64
+	}{
+		{"a\nb\nc\n", 1, 4},
+		{"a\nb\nc\n", 2, 2},
+		{"a\nb\nc\n", 3, 0},
+		{"a\nb\nc\n", 4, 0},
+		{"a\nb\nc", 1, 4},
+		{"a\nb\nc", 2, 2},
+		{"\na\nb\n", 2, 1},
+		{"\na\nb\n", 3, 0},
+		{"a\n\n\n", 2, 2},
+		{"abc", 1, 0},
+		{"abc\n", 1, 0},
+		{"", 1, 0},
+		{"\n", 1, 0},
+		// Lines longer than a block
+		{long + long + long, 1, 12000},
+		{long + long + long, 2, 6000},
+		{long + long + long, 3, 0},
+		{long + long + "end", 1, 12000},
+		{long + long + "end", 2, 6000},
+		{"a\n" + strings.Repeat("y", 20000), 1, 2},
+	}
+
+	file, err := ioutil.TempFile("", "stor-tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	provider := &providers.FileProvider{}
+	tokenBucket := providers.InitTokenBucket()
+	for _, test := range tests {
+		err = ioutil.WriteFile(file.Name(), []byte(test.data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := tailStart(provider, file.Name(), int64(len(test.data)), test.lines, tokenBucket)
+		if err != nil || got != test.want {
+			name := test.data
+			if len(name) > 20 {
+				name = name[:10] + "..." + name[len(name)-10:]
+			}
+			t.Errorf("tailStart(%q, %d) = %d, %v, want %d", name, test.lines, got, err, test.want)
+		}
+	}
+}
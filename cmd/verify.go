@@ -0,0 +1,256 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hahutton/stor/providers"
+	"github.com/spf13/cobra"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+var verifyRecurse bool
+var verifySizeOnly bool
+
+// Verify outcomes. Anything but verifyOK is a difference.
+const (
+	verifyOK       = "ok"
+	verifyMissing  = "missing"
+	verifyExtra    = "extra"
+	verifySize     = "size"
+	verifyMD5      = "md5"
+	verifyNoMD5    = "nomd5"
+	verifyReadFail = "error"
+)
+
+type verifyResult struct {
+	status string
+	local  *providers.BlobInfo
+	target string
+	detail string
+}
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify local_file... //alias/target",
+	Short: "Compare local files against blobs by size and MD5",
+	Long: `Compare local files against the blobs cp would have copied them to.
+
+Each local file is paired with a blob using the same target naming as cp, so
+'stor verify -R logs //alias/backup/' checks what 'stor cp -R logs //alias/backup/'
+uploaded. Lengths are compared first and then the local MD5, computed in
+parallel, against the blob's Content-MD5.
+
+Differences are printed one per line:
+  missing  the blob does not exist
+  extra    a blob under the target prefix has no local file
+  size     the lengths differ
+  md5      the checksums differ
+  nomd5    the blob has no Content-MD5 to compare (see --size-only)
+  error    the local file could not be read
+
+verify exits non-zero when there is any difference.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		start := time.Now()
+
+		targetPosition := len(args) - 1
+		targetAlias, targetPathName, err := providers.Parse(args[targetPosition])
+		if err != nil {
+			return err
+		}
+		targetProvider, err := providers.Create(targetAlias)
+		if err != nil {
+			return err
+		}
+		if targetProvider.ProviderName() == "file" {
+			return configError("verify compares local files against an object store. %s is local.", args[targetPosition])
+		}
+		localProvider, err := providers.Create("file")
+		if err != nil {
+			return err
+		}
+
+		var results []*verifyResult
+		expected := make(map[string]*verifyResult)
+		for _, arg := range args[:targetPosition] {
+			alias, pathName, err := providers.Parse(arg)
+			if err != nil {
+				return err
+			}
+			if alias != "file" {
+				return configError("verify sources must be local files. %s is not.", arg)
+			}
+			localInfos, err := localSources(localProvider, pathName, verifyRecurse)
+			if err != nil {
+				return err
+			}
+			for _, localInfo := range localInfos {
+				target := targetFor(targetPathName, localInfo.PathName)
+				result := &verifyResult{status: verifyMissing, local: localInfo, target: target}
+				results = append(results, result)
+				expected[blobKey(target)] = result
+			}
+		}
+
+		remote, err := verifyRemote(targetProvider, targetPathName)
+		if err != nil {
+			return err
+		}
+
+		var extras []string
+		for key := range remote {
+			if _, ok := expected[key]; !ok {
+				extras = append(extras, key)
+			}
+		}
+		sort.Strings(extras)
+
+		//Only files whose lengths match are worth reading for their MD5
+		var wg sync.WaitGroup
+		tokenBucket := providers.InitTokenBucket()
+		for _, result := range results {
+			blobInfo, ok := remote[blobKey(result.target)]
+			if !ok {
+				continue
+			}
+			if blobInfo.Length != result.local.Length {
+				result.status = verifySize
+				result.detail = fmt.Sprintf("local %d, remote %d", result.local.Length, blobInfo.Length)
+				continue
+			}
+			if verifySizeOnly {
+				result.status = verifyOK
+				continue
+			}
+			if blobInfo.MD5 == "" {
+				result.status = verifyNoMD5
+				continue
+			}
+
+			token := <-tokenBucket
+			wg.Add(1)
+			go func(result *verifyResult, remoteMD5 string, token int) {
+				defer func() { tokenBucket <- token }()
+				defer wg.Done()
+				localMD5, err := fileMD5(result.local.PathName)
+				switch {
+				case err != nil:
+					result.status = verifyReadFail
+					result.detail = err.Error()
+				case localMD5 != remoteMD5:
+					result.status = verifyMD5
+					result.detail = fmt.Sprintf("local %s, remote %s", localMD5, remoteMD5)
+				default:
+					result.status = verifyOK
+				}
+			}(result, blobInfo.MD5, token)
+		}
+		wg.Wait()
+
+		var matched, differences int
+		for _, result := range results {
+			if result.status == verifyOK {
+				matched++
+				jww.INFO.Printf("ok %s -> %s", result.local.PathName, result.target)
+				continue
+			}
+			differences++
+			line := fmt.Sprintf("%-8s %s -> %s", result.status, result.local.PathName, result.target)
+			if result.detail != "" {
+				line = fmt.Sprintf("%s (%s)", line, result.detail)
+			}
+			fmt.Println(line)
+		}
+		for _, extra := range extras {
+			differences++
+			fmt.Printf("%-8s %s\n", verifyExtra, extra)
+		}
+
+		jww.INFO.Printf("Elapsed: %v\n", time.Since(start))
+		fmt.Printf("%d matched, %d differences\n", matched, differences)
+		if differences > 0 {
+			return fmt.Errorf("%d differences between local files and %s", differences, args[targetPosition])
+		}
+		return nil
+	},
+}
+
+// verifyRemote lists the blobs a verify compares against keyed by blobKey. A
+// target ending in / is a prefix listed in full and anything else a single blob.
+func verifyRemote(provider providers.Provider, targetPathName string) (map[string]*providers.BlobInfo, error) {
+	remote := make(map[string]*providers.BlobInfo)
+	if !isDir(targetPathName) {
+		blobInfo, err := provider.Stat(targetPathName)
+		if providers.KindOf(err) == providers.NotFound {
+			return remote, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		remote[blobKey(targetPathName)] = blobInfo
+		return remote, nil
+	}
+
+	err := provider.Walk(targetPathName, func(blobInfo *providers.BlobInfo) error {
+		remote[blobKey(blobInfo.PathName)] = blobInfo
+		return nil
+	})
+	return remote, err
+}
+
+// blobKey is name as the object store names it, without the leading /.
+func blobKey(name string) string {
+	return strings.TrimPrefix(name, "/")
+}
+
+// fileMD5 is the base64 MD5 of a local file, as Azure reports Content-MD5.
+func fileMD5(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+func init() {
+	RootCmd.AddCommand(verifyCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// verifyCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	verifyCmd.Flags().BoolVarP(&verifyRecurse, "Recurse", "R", false, "Recurse directories like cp -R")
+	verifyCmd.Flags().BoolVar(&verifySizeOnly, "size-only", false, "compare lengths only, e.g. for blobs uploaded without an MD5")
+}
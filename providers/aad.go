@@ -0,0 +1,273 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	jww "github.com/spf13/jwalterweatherman"
+	"github.com/spf13/viper"
+)
+
+const (
+	AAD_AUTHORITY_HOST = "https://login.microsoftonline.com"
+	AAD_STORAGE_SCOPE  = "https://storage.azure.com/.default"
+	//Tokens are refreshed once this fraction of their lifetime has passed so none
+	//expire mid request, however short lived they are
+	AAD_REFRESH_FRACTION = 0.8
+	//The lifetime assumed when a token response has no usable expires_in
+	AAD_DEFAULT_LIFETIME = time.Hour
+)
+
+// tokenSource fetches a bearer token from an Azure AD token endpoint with the
+// OAuth2 client credentials grant and caches it until most of its lifetime is up.
+// The client authenticates with either a secret or a certificate.
+type tokenSource struct {
+	endpoint    string
+	clientId    string
+	secret      string
+	certificate *x509.Certificate
+	key         *rsa.PrivateKey
+
+	mu      sync.Mutex
+	token   string
+	refresh time.Time
+}
+
+type tokenResponse struct {
+	AccessToken      string      `json:"access_token"`
+	ExpiresIn        interface{} `json:"expires_in"`
+	Error            string      `json:"error"`
+	ErrorDescription string      `json:"error_description"`
+}
+
+// tokenSources are shared by every provider using the same endpoint and client
+// so a command only fetches one token per identity.
+var tokenSources = make(map[string]*tokenSource)
+var tokenSourcesMu sync.Mutex
+
+// newTokenSource builds the token source for an alias with tenantId and clientId
+// plus either clientSecret or clientCertificate, a PEM file holding the certificate
+// and its RSA private key. tokenEndpoint replaces the Azure AD endpoint entirely and
+// authorityHost replaces just the host, e.g. for sovereign clouds.
+func newTokenSource(alias string, sub *viper.Viper) (*tokenSource, error) {
+	ts := &tokenSource{
+		endpoint: sub.GetString("tokenEndpoint"),
+		clientId: sub.GetString("clientId"),
+		secret:   sub.GetString("clientSecret"),
+	}
+
+	if ts.endpoint == "" {
+		tenantId := sub.GetString("tenantId")
+		if tenantId == "" {
+			return nil, configError("alias %s needs tenantId or tokenEndpoint with clientId", alias)
+		}
+		authorityHost := sub.GetString("authorityHost")
+		if authorityHost == "" {
+			authorityHost = AAD_AUTHORITY_HOST
+		}
+		ts.endpoint = fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(authorityHost, "/"), tenantId)
+	}
+
+	certificateFile := sub.GetString("clientCertificate")
+	if certificateFile != "" {
+		err := ts.loadCertificate(certificateFile)
+		if err != nil {
+			return nil, &Error{Kind: InvalidConfig, Message: fmt.Sprintf("alias %s clientCertificate", alias), Err: err}
+		}
+	} else if ts.secret == "" {
+		return nil, configError("alias %s needs clientSecret or clientCertificate with clientId", alias)
+	}
+
+	tokenSourcesMu.Lock()
+	defer tokenSourcesMu.Unlock()
+	cacheKey := ts.endpoint + " " + ts.clientId
+	if cached, ok := tokenSources[cacheKey]; ok {
+		return cached, nil
+	}
+	tokenSources[cacheKey] = ts
+	return ts, nil
+}
+
+func (ts *tokenSource) loadCertificate(name string) error {
+	pemBytes, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	for {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			if ts.certificate == nil {
+				ts.certificate, err = x509.ParseCertificate(block.Bytes)
+			}
+		case "RSA PRIVATE KEY":
+			ts.key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			var key interface{}
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+			if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+				ts.key = rsaKey
+			} else if err == nil {
+				err = fmt.Errorf("private key in %s is not RSA", name)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if ts.certificate == nil || ts.key == nil {
+		return fmt.Errorf("%s needs both a CERTIFICATE and a PRIVATE KEY block", name)
+	}
+	return nil
+}
+
+// Token returns the cached token or fetches a new one when it is missing or
+// AAD_REFRESH_FRACTION of its lifetime has passed.
+func (ts *tokenSource) Token() (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token != "" && time.Now().Before(ts.refresh) {
+		return ts.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", ts.clientId)
+	form.Set("scope", AAD_STORAGE_SCOPE)
+	if ts.key != nil {
+		assertion, err := ts.clientAssertion()
+		if err != nil {
+			return "", err
+		}
+		form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		form.Set("client_assertion", assertion)
+	} else {
+		form.Set("client_secret", ts.secret)
+	}
+
+	jww.INFO.Println("Fetching bearer token from:", ts.endpoint)
+	req, err := retryablehttp.NewRequest("POST", ts.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, resBody, err := send(req)
+	if err != nil {
+		return "", err
+	}
+
+	var tokenRes tokenResponse
+	err = json.Unmarshal(resBody, &tokenRes)
+	if res.StatusCode != 200 || err != nil || tokenRes.AccessToken == "" {
+		//Only the first line of the description. The rest is trace and correlation ids
+		description := strings.TrimSpace(strings.SplitN(strings.TrimSpace(tokenRes.ErrorDescription), "\n", 2)[0])
+		return "", &Error{
+			Kind:       AuthFailed,
+			StatusCode: res.StatusCode,
+			Code:       tokenRes.Error,
+			Message:    "token request failed. " + description,
+			Err:        err,
+		}
+	}
+
+	lifetime := tokenLifetime(tokenRes.ExpiresIn)
+	now := time.Now()
+	ts.token = tokenRes.AccessToken
+	ts.refresh = now.Add(time.Duration(float64(lifetime) * AAD_REFRESH_FRACTION))
+	jww.INFO.Println("Bearer token expires:", now.Add(lifetime), "and is refreshed after:", ts.refresh)
+	return ts.token, nil
+}
+
+// tokenLifetime is expires_in as a duration, or AAD_DEFAULT_LIFETIME when it is
+// missing or not a positive number of seconds.
+func tokenLifetime(expiresIn interface{}) time.Duration {
+	//v1 endpoints send expires_in as a string and v2 as a number
+	var seconds float64
+	switch value := expiresIn.(type) {
+	case float64:
+		seconds = value
+	case string:
+		seconds, _ = strconv.ParseFloat(value, 64)
+	}
+	if seconds <= 0 {
+		return AAD_DEFAULT_LIFETIME
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// clientAssertion is the RS256 signed JWT that proves possession of the
+// certificate's private key in place of a client secret.
+func (ts *tokenSource) clientAssertion() (string, error) {
+	thumbprint := sha1.Sum(ts.certificate.Raw)
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"x5t": base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	jti := make([]byte, 16)
+	_, err = rand.Read(jti)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().Unix()
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": ts.endpoint,
+		"iss": ts.clientId,
+		"sub": ts.clientId,
+		"jti": hex.EncodeToString(jti),
+		"nbf": now,
+		"exp": now + 600,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, ts.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
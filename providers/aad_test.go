@@ -0,0 +1,205 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tokenServer answers every token request with status and body and counts them.
+func tokenServer(status int, body string) (*httptest.Server, func() int) {
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	return server, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestTokenLifetime(t *testing.T) {
+	tests := []struct {
+		body     string
+		lifetime time.Duration
+	}{
+		{`{"access_token":"t","expires_in":3599}`, 3599 * time.Second},
+		{`{"access_token":"t","expires_in":"3599"}`, 3599 * time.Second},
+		{`{"access_token":"t","expires_in":60}`, time.Minute},
+		{`{"access_token":"t","expires_in":"60"}`, time.Minute},
+		{`{"access_token":"t"}`, AAD_DEFAULT_LIFETIME},
+		{`{"access_token":"t","expires_in":"soon"}`, AAD_DEFAULT_LIFETIME},
+		{`{"access_token":"t","expires_in":0}`, AAD_DEFAULT_LIFETIME},
+	}
+	for _, test := range tests {
+		server, requests := tokenServer(http.StatusOK, test.body)
+		ts := &tokenSource{endpoint: server.URL, clientId: "id", secret: "secret"}
+
+		before := time.Now()
+		for i := 0; i < 3; i++ {
+			token, err := ts.Token()
+			if err != nil || token != "t" {
+				t.Errorf("%s: Token() = %q, %v", test.body, token, err)
+			}
+		}
+		after := time.Now()
+		server.Close()
+
+		if requests() != 1 {
+			t.Errorf("%s: %d token requests, want 1 while it is fresh", test.body, requests())
+		}
+		refreshIn := time.Duration(float64(test.lifetime) * AAD_REFRESH_FRACTION)
+		if ts.refresh.Before(before.Add(refreshIn)) || ts.refresh.After(after.Add(refreshIn)) {
+			t.Errorf("%s: refreshed after %v, want %v from now", test.body, ts.refresh.Sub(before), refreshIn)
+		}
+	}
+}
+
+// Once the refresh time has passed the next Token fetches a new one.
+func TestTokenRefresh(t *testing.T) {
+	server, requests := tokenServer(http.StatusOK, `{"access_token":"t","expires_in":3599}`)
+	defer server.Close()
+	ts := &tokenSource{endpoint: server.URL, clientId: "id", secret: "secret"}
+
+	_, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.refresh = time.Now().Add(-time.Second)
+	_, err = ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests() != 2 {
+		t.Errorf("%d token requests, want 2", requests())
+	}
+}
+
+func TestTokenError(t *testing.T) {
+	body := `{"error":"invalid_client","error_description":"AADSTS7000215: Invalid client secret provided.\r\nTrace ID: 0000\r\nCorrelation ID: 1111"}`
+	server, _ := tokenServer(http.StatusUnauthorized, body)
+	defer server.Close()
+	ts := &tokenSource{endpoint: server.URL, clientId: "id", secret: "wrong"}
+
+	_, err := ts.Token()
+	var tokenErr *Error
+	if !errors.As(err, &tokenErr) {
+		t.Fatalf("Token() error %v is not an *Error", err)
+	}
+	if tokenErr.Kind != AuthFailed || tokenErr.StatusCode != http.StatusUnauthorized || tokenErr.Code != "invalid_client" {
+		t.Errorf("got %v %d %s, want AuthFailed 401 invalid_client", tokenErr.Kind, tokenErr.StatusCode, tokenErr.Code)
+	}
+	if tokenErr.Message != "token request failed. AADSTS7000215: Invalid client secret provided." {
+		t.Errorf("message %q should end at the first line of the description", tokenErr.Message)
+	}
+	if ts.token != "" {
+		t.Errorf("a failed request left token %q cached", ts.token)
+	}
+}
+
+func TestClientAssertion(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "stor"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	endpoint := "https://login.microsoftonline.com/tenant/oauth2/v2.0/token"
+	ts := &tokenSource{endpoint: endpoint, clientId: "id", certificate: certificate, key: key}
+	assertion, err := ts.clientAssertion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		t.Fatalf("assertion has %d parts, want 3", len(parts))
+	}
+	decode := func(part string, v interface{}) {
+		t.Helper()
+		data, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = json.Unmarshal(data, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var header map[string]string
+	decode(parts[0], &header)
+	thumbprint := sha1.Sum(certificate.Raw)
+	if header["alg"] != "RS256" || header["x5t"] != base64.RawURLEncoding.EncodeToString(thumbprint[:]) {
+		t.Errorf("header = %v", header)
+	}
+
+	var claims map[string]interface{}
+	decode(parts[1], &claims)
+	if claims["aud"] != endpoint || claims["iss"] != "id" || claims["sub"] != "id" {
+		t.Errorf("claims = %v", claims)
+	}
+	now := float64(time.Now().Unix())
+	if nbf, _ := claims["nbf"].(float64); nbf > now {
+		t.Errorf("nbf %v is after now %v", nbf, now)
+	}
+	if exp, _ := claims["exp"].(float64); exp <= now {
+		t.Errorf("exp %v is not after now %v", exp, now)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(certificate.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature)
+	if err != nil {
+		t.Errorf("signature does not verify with the certificate: %v", err)
+	}
+}
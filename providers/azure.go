@@ -16,12 +16,18 @@ package providers
 import (
 	"bytes"
 	"crypto/hmac"
+	"crypto/md5"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"os"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"text/template"
@@ -29,14 +35,111 @@ import (
 
 	"github.com/hashicorp/go-retryablehttp"
 	jww "github.com/spf13/jwalterweatherman"
+	"github.com/spf13/viper"
 )
 
 const AZ_STORAGE_BASE = "blob.core.windows.net"
 
+// AzureProvider authorizes requests with SharedKey signing when Key is set, by
+// appending the SAS query string when SAS is set or with an Azure AD bearer
+// token when the alias has a clientId. Endpoint is the blob service url without
+// the container, e.g. https://account.blob.core.windows.net or the path style
+// http://127.0.0.1:10000/devstoreaccount1 of the Azurite emulator.
 type AzureProvider struct {
 	AccountName   string
 	ContainerName string
 	Key           string
+	SAS           string
+	Endpoint      string
+	tokens        *tokenSource
+}
+
+// newAzureProvider builds the provider from an alias' config. A connectionString
+// fills in whichever of the account, key, sas and endpoint the alias leaves unset. The sas setting
+// may be the SAS query string or a full container SAS URL, in which case the
+// endpoint, account and container are taken from the URL when not set on the alias.
+// endpoint sets the blob service url outright while suffix only replaces
+// blob.core.windows.net, e.g. blob.core.chinacloudapi.cn.
+func newAzureProvider(alias string, sub *viper.Viper) (*AzureProvider, error) {
+	azure := &AzureProvider{
+		AccountName:   sub.GetString("accountName"),
+		ContainerName: sub.GetString("name"),
+		Key:           sub.GetString("key"),
+		SAS:           strings.TrimPrefix(sub.GetString("sas"), "?"),
+		Endpoint:      strings.TrimSuffix(sub.GetString("endpoint"), "/"),
+	}
+
+	if connectionString := sub.GetString("connectionString"); connectionString != "" {
+		err := azure.applyConnectionString(connectionString)
+		if err != nil {
+			return nil, &Error{Kind: InvalidConfig, Message: fmt.Sprintf("alias %s connectionString", alias), Err: err}
+		}
+	}
+
+	if strings.HasPrefix(azure.SAS, "https://") || strings.HasPrefix(azure.SAS, "http://") {
+		sasURL, err := url.Parse(azure.SAS)
+		if err != nil {
+			return nil, &Error{Kind: InvalidConfig, Message: fmt.Sprintf("alias %s sas is not a url", alias), Err: err}
+		}
+		endpoint, account, container := splitServiceURL(sasURL)
+		if azure.Endpoint == "" {
+			azure.Endpoint = endpoint
+		}
+		if azure.AccountName == "" {
+			azure.AccountName = account
+		}
+		if azure.ContainerName == "" {
+			azure.ContainerName = container
+		}
+		azure.SAS = sasURL.RawQuery
+	}
+
+	if azure.Endpoint != "" {
+		endpointURL, err := url.Parse(azure.Endpoint)
+		if err != nil || (endpointURL.Scheme != "https" && endpointURL.Scheme != "http") || endpointURL.Host == "" {
+			return nil, &Error{Kind: InvalidConfig, Message: fmt.Sprintf("alias %s endpoint is not an http(s) url: %s", alias, azure.Endpoint), Err: err}
+		}
+		_, account, _ := splitServiceURL(endpointURL)
+		if azure.AccountName == "" {
+			azure.AccountName = account
+		}
+	}
+
+	if azure.AccountName == "" || azure.ContainerName == "" {
+		return nil, configError("alias %s needs accountName and name", alias)
+	}
+
+	if azure.Endpoint == "" {
+		suffix := strings.Trim(sub.GetString("suffix"), "./")
+		if suffix == "" {
+			suffix = AZ_STORAGE_BASE
+		}
+		azure.Endpoint = fmt.Sprintf("https://%s.%s", azure.AccountName, suffix)
+	}
+	jww.TRACE.Printf("Alias %s endpoint: %s", alias, azure.Endpoint)
+	if azure.SAS != "" {
+		_, err := url.ParseQuery(azure.SAS)
+		if err != nil {
+			return nil, &Error{Kind: InvalidConfig, Message: fmt.Sprintf("alias %s sas is not a query string", alias), Err: err}
+		}
+		return azure, nil
+	}
+	if sub.GetString("clientId") != "" {
+		tokens, err := newTokenSource(alias, sub)
+		if err != nil {
+			return nil, err
+		}
+		azure.tokens = tokens
+		return azure, nil
+	}
+	if azure.Key == "" {
+		return nil, configError("alias %s needs a key, sas or clientId", alias)
+	}
+	_, err := base64.StdEncoding.DecodeString(azure.Key)
+	if err != nil {
+		return nil, &Error{Kind: InvalidConfig, Message: fmt.Sprintf("alias %s key is not base64", alias), Err: err}
+	}
+	return azure, nil
 }
 
 type signingRequest struct {
@@ -57,6 +160,11 @@ type signingRequest struct {
 	FileName          string
 	BlockId           string
 	TypeName          string
+	MsRange           string
+	BlobContentMD5    string
+	Prefix            string
+	Marker            string
+	MaxResults        int
 }
 
 const get_blob_list_auth_header string = `{{ .Verb }}
@@ -73,7 +181,10 @@ const get_blob_list_auth_header string = `{{ .Verb }}
 {{ .Range }}
 x-ms-version:2017-11-09
 /{{ .Account }}/{{ .Container }}
-comp:list
+comp:list{{ if .Marker }}
+marker:{{ .Marker }}{{ end }}{{ if .MaxResults }}
+maxresults:{{ .MaxResults }}{{ end }}{{ if .Prefix }}
+prefix:{{ .Prefix }}{{ end }}
 restype:container`
 
 const put_block_auth_header string = `{{ .Verb }}
@@ -93,10 +204,59 @@ x-ms-version:2017-11-09
 blockid:{{ .BlockId }}
 comp:{{ .TypeName -}}`
 
+const put_blob_auth_header string = `{{ .Verb }}
+{{ .ContentEncoding }}
+{{ .ContentLanguage }}
+{{ if .ContentLength }}{{ .ContentLength }}{{ end }}
+{{ .ContentMD5 }}
+{{ .ContentType }}
+{{ .Date }}
+{{ .IfModifiedSince }}
+{{ .IfMatch }}
+{{ .IfNoneMatch }}
+{{ .IfUnmodifiedSince }}
+{{ .Range }}
+x-ms-blob-type:BlockBlob
+x-ms-version:2017-11-09
+/{{ .Account }}/{{ .Container }}/{{ .FileName -}}`
+
 const put_block_list_auth_header string = `{{ .Verb }}
 {{ .ContentEncoding }}
 {{ .ContentLanguage }}
 {{ .ContentLength }}
+{{ .ContentMD5 }}
+{{ .ContentType }}
+{{ .Date }}
+{{ .IfModifiedSince }}
+{{ .IfMatch }}
+{{ .IfNoneMatch }}
+{{ .IfUnmodifiedSince }}
+{{ .Range }}
+x-ms-blob-content-md5:{{ .BlobContentMD5 }}
+x-ms-version:2017-11-09
+/{{ .Account }}/{{ .Container }}/{{ .FileName }}
+comp:{{ .TypeName -}}`
+
+const get_blob_auth_header string = `{{ .Verb }}
+{{ .ContentEncoding }}
+{{ .ContentLanguage }}
+
+{{ .ContentMD5 }}
+{{ .ContentType }}
+{{ .Date }}
+{{ .IfModifiedSince }}
+{{ .IfMatch }}
+{{ .IfNoneMatch }}
+{{ .IfUnmodifiedSince }}
+{{ .Range }}
+x-ms-range:{{ .MsRange }}
+x-ms-version:2017-11-09
+/{{ .Account }}/{{ .Container }}/{{ .FileName -}}`
+
+const get_block_list_auth_header string = `{{ .Verb }}
+{{ .ContentEncoding }}
+{{ .ContentLanguage }}
+
 {{ .ContentMD5 }}
 {{ .ContentType }}
 {{ .Date }}
@@ -107,8 +267,24 @@ const put_block_list_auth_header string = `{{ .Verb }}
 {{ .Range }}
 x-ms-version:2017-11-09
 /{{ .Account }}/{{ .Container }}/{{ .FileName }}
+blocklisttype:uncommitted
 comp:{{ .TypeName -}}`
 
+const blob_auth_header string = `{{ .Verb }}
+{{ .ContentEncoding }}
+{{ .ContentLanguage }}
+
+{{ .ContentMD5 }}
+{{ .ContentType }}
+{{ .Date }}
+{{ .IfModifiedSince }}
+{{ .IfMatch }}
+{{ .IfNoneMatch }}
+{{ .IfUnmodifiedSince }}
+{{ .Range }}
+x-ms-version:2017-11-09
+/{{ .Account }}/{{ .Container }}/{{ .FileName -}}`
+
 const put_block_list_body string = `<?xml version="1.0" encoding="utf-8"?>
 <BlockList>
 {{range .}}
@@ -116,10 +292,24 @@ const put_block_list_body string = `<?xml version="1.0" encoding="utf-8"?>
 {{end}}
 </BlockList>`
 
+type BlockList struct {
+	CommittedBlocks   []BlockEntry `xml:"CommittedBlocks>Block"`
+	UncommittedBlocks []BlockEntry `xml:"UncommittedBlocks>Block"`
+}
+
+type BlockEntry struct {
+	Name string `xml:"Name"`
+	Size int    `xml:"Size"`
+}
+
 type EnumerationResults struct {
 	Blobs         []Blob `xml:"Blobs>Blob"`
 	EndPoint      string `xml:"ServiceEndpoint,attr"`
 	ContainerName string `xml:"ContainerName,attr"`
+	Prefix        string `xml:"Prefix"`
+	Marker        string `xml:"Marker"`
+	MaxResults    int    `xml:"MaxResults"`
+	NextMarker    string `xml:"NextMarker"`
 }
 
 type Blob struct {
@@ -142,178 +332,568 @@ type Blob struct {
 	ServerEncrypted    bool   `xml:"Properties>ServerEncrypted"`
 }
 
+// azureError is the body Azure returns with non 2xx responses.
+type azureError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
 var client = retryablehttp.NewClient()
 
 func init() {
 	//Turn off debug???
 	client.Logger = nil
+	//Hand back the last response once retries run out so checkResponse can
+	//still tell a throttled request from any other failure
+	client.ErrorHandler = retryablehttp.PassthroughErrorHandler
 }
 
 func (azure *AzureProvider) returnToken(tokenBucket chan<- int, token int) {
 	tokenBucket <- token
 }
 
+// The account and key of the Azurite and storage emulator development account.
+const (
+	DEV_STORAGE_ACCOUNT  = "devstoreaccount1"
+	DEV_STORAGE_KEY      = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+	DEV_STORAGE_ENDPOINT = "http://127.0.0.1:10000/devstoreaccount1"
+)
+
+// applyConnectionString sets the unset fields from a storage connection string like
+// DefaultEndpointsProtocol=https;AccountName=...;AccountKey=...;EndpointSuffix=core.windows.net
+// BlobEndpoint takes precedence over the protocol and suffix, SharedAccessSignature
+// stands in for AccountKey and UseDevelopmentStorage=true targets the local emulator.
+func (azure *AzureProvider) applyConnectionString(connectionString string) error {
+	settings := make(map[string]string)
+	for _, pair := range strings.Split(connectionString, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		//Keys and signatures end in = padding so only the first = separates the value
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		settings[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+	}
+
+	if strings.EqualFold(settings["usedevelopmentstorage"], "true") {
+		settings["accountname"] = DEV_STORAGE_ACCOUNT
+		settings["accountkey"] = DEV_STORAGE_KEY
+		if settings["blobendpoint"] == "" {
+			settings["blobendpoint"] = DEV_STORAGE_ENDPOINT
+		}
+	}
+
+	if azure.AccountName == "" {
+		azure.AccountName = settings["accountname"]
+	}
+	if azure.Key == "" {
+		azure.Key = settings["accountkey"]
+	}
+	if azure.SAS == "" {
+		azure.SAS = strings.TrimPrefix(settings["sharedaccesssignature"], "?")
+	}
+	if azure.Endpoint != "" {
+		return nil
+	}
+
+	if settings["blobendpoint"] != "" {
+		azure.Endpoint = strings.TrimSuffix(settings["blobendpoint"], "/")
+		return nil
+	}
+	protocol, suffix := settings["defaultendpointsprotocol"], settings["endpointsuffix"]
+	if protocol == "" && suffix == "" {
+		return nil
+	}
+	if azure.AccountName == "" {
+		return errors.New("AccountName or BlobEndpoint is required")
+	}
+	if protocol == "" {
+		protocol = "https"
+	}
+	if suffix == "" {
+		suffix = strings.TrimPrefix(AZ_STORAGE_BASE, "blob.")
+	}
+	azure.Endpoint = fmt.Sprintf("%s://%s.blob.%s", protocol, azure.AccountName, suffix)
+	return nil
+}
+
+// splitServiceURL takes the blob service endpoint, account and container from a
+// url. Hosts like account.blob.core.windows.net carry the account in the host name
+// while path style urls like http://127.0.0.1:10000/account/container carry it in the path.
+func splitServiceURL(serviceURL *url.URL) (endpoint string, account string, container string) {
+	segments := strings.Split(strings.Trim(serviceURL.Path, "/"), "/")
+	base := fmt.Sprintf("%s://%s", serviceURL.Scheme, serviceURL.Host)
+
+	if strings.Contains(serviceURL.Hostname(), ".blob.") {
+		return base, strings.SplitN(serviceURL.Hostname(), ".", 2)[0], segments[0]
+	}
+
+	if len(segments) > 1 {
+		container = segments[1]
+	}
+	return fmt.Sprintf("%s/%s", base, segments[0]), segments[0], container
+}
+
 func (azure *AzureProvider) endPoint() string {
-	return fmt.Sprintf("https://%s.%s/%s", azure.AccountName, AZ_STORAGE_BASE, azure.ContainerName)
+	return fmt.Sprintf("%s/%s", azure.Endpoint, azure.ContainerName)
 }
 
-func (azure *AzureProvider) putBlock(block *Block, blockId string, name string, token int) int {
-	datetime := time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
-	tmpl, err := template.New("put_block_auth_header").Parse(put_block_auth_header)
-	if err != nil {
-		jww.ERROR.Println("bad tmpl")
-		jww.ERROR.Println(err)
+// resourcePath is the part of the canonicalized resource between the account and
+// the blob name. Path style endpoints repeat the account there ahead of the container.
+func (azure *AzureProvider) resourcePath() string {
+	endpointURL, err := url.Parse(azure.Endpoint)
+	if err != nil || strings.Trim(endpointURL.Path, "/") == "" {
+		return azure.ContainerName
 	}
+	return fmt.Sprintf("%s/%s", strings.Trim(endpointURL.Path, "/"), azure.ContainerName)
+}
 
-	s := signingRequest{}
-	s.Verb = "PUT"
-	s.ContentLength = len(block.Bytes)
-	s.Date = datetime
-	s.Account = azure.AccountName
-	s.Container = azure.ContainerName
-	s.FileName = strings.TrimPrefix(name, "/")
-	s.BlockId = blockId
-	s.TypeName = "block"
+// blobPath returns name with exactly one leading '/' so it can be appended to
+// endPoint(). Each segment is escaped so a '#', '?' or '%' in a blob name stays
+// part of the path. The SharedKey canonicalized resource uses the same escaped path.
+func blobPath(name string) string {
+	segments := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// authorize renders the string to sign from authTemplate and s and adds the
+// SharedKey Authorization header plus the headers it covers to req. With a SAS
+// the token is appended to the query instead and nothing is signed. With Azure
+// AD the cached bearer token is sent instead.
+func (azure *AzureProvider) authorize(req *retryablehttp.Request, authTemplate string, s signingRequest) error {
+	if azure.SAS != "" {
+		if req.URL.RawQuery == "" {
+			req.URL.RawQuery = azure.SAS
+		} else {
+			req.URL.RawQuery = req.URL.RawQuery + "&" + azure.SAS
+		}
+		req.Header.Add("Date", s.Date)
+		req.Header.Add("x-ms-version", "2017-11-09")
+		return nil
+	}
+
+	if azure.tokens != nil {
+		token, err := azure.tokens.Token()
+		if err != nil {
+			return err
+		}
+		req.Header.Add("Authorization", "Bearer "+token)
+		req.Header.Add("Date", s.Date)
+		req.Header.Add("x-ms-version", "2017-11-09")
+		return nil
+	}
+
+	tmpl, err := template.New("auth_header").Parse(authTemplate)
+	if err != nil {
+		return err
+	}
 
 	var builder strings.Builder
-	tmpl.Execute(&builder, s)
+	err = tmpl.Execute(&builder, s)
+	if err != nil {
+		return err
+	}
+	jww.TRACE.Println(builder.String())
 
 	decodedKey, err := base64.StdEncoding.DecodeString(azure.Key)
 	if err != nil {
-		jww.ERROR.Println("Bad base64 key: ", err)
-		os.Exit(1)
+		return &Error{Kind: InvalidConfig, Message: "bad base64 key", Err: err}
 	}
 
 	h := hmac.New(sha256.New, decodedKey)
 	h.Write([]byte(builder.String()))
 	authKey := fmt.Sprintf("SharedKey %s:%s", azure.AccountName, base64.StdEncoding.EncodeToString(h.Sum(nil)))
-	jww.TRACE.Println(builder.String())
-
-	//Assumes name begins with '/'
-	target := fmt.Sprintf("%s%s?comp=block&blockid=%s", azure.endPoint(), name, blockId)
-	jww.TRACE.Println("target http request:", target)
 
-	body := bytes.NewReader(block.Bytes)
-	req, err := retryablehttp.NewRequest("PUT", target, body)
-	if err != nil {
-		jww.ERROR.Println("Bad build of http request structure.", err)
-		os.Exit(1)
-	}
 	req.Header.Add("Authorization", authKey)
-	req.Header.Add("Date", datetime)
+	req.Header.Add("Date", s.Date)
 	req.Header.Add("x-ms-version", "2017-11-09")
+	return nil
+}
 
+// do sends req. The query is cut from the url in errors so a SAS signature
+// never reaches the logs or a job's journal.
+func do(req *retryablehttp.Request) (*http.Response, error) {
 	res, err := client.Do(req)
+	if urlErr, ok := err.(*url.Error); ok {
+		urlErr.URL = strings.SplitN(urlErr.URL, "?", 2)[0]
+	}
+	return res, err
+}
+
+// send does req and reads the whole response body.
+func send(req *retryablehttp.Request) (*http.Response, []byte, error) {
+	res, err := do(req)
 	if err != nil {
-		jww.ERROR.Println("bad http request.", err)
-		os.Exit(1)
+		return nil, nil, err
 	}
 	defer res.Body.Close()
 
-	resBody, _ := ioutil.ReadAll(res.Body)
+	resBody, err := ioutil.ReadAll(res.Body)
 	jww.TRACE.Println(res)
+	return res, resBody, err
+}
+
+// checkResponse turns a non 2xx response into an *Error carrying Azure's error code and message.
+func checkResponse(res *http.Response, resBody []byte) error {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
 	jww.TRACE.Printf("%s\n", resBody)
 
-	return res.StatusCode
+	var azureErr azureError
+	xml.Unmarshal(resBody, &azureErr)
+	if azureErr.Code == "" {
+		//HEAD responses have no body
+		azureErr.Code = res.Header.Get("x-ms-error-code")
+	}
+	//The message trails off with RequestId and Time lines
+	message := strings.SplitN(strings.TrimSpace(azureErr.Message), "\n", 2)[0]
+
+	return &Error{
+		Kind:       statusKind(res.StatusCode, azureErr.Code),
+		StatusCode: res.StatusCode,
+		Code:       azureErr.Code,
+		Message:    message,
+	}
+}
+
+func (azure *AzureProvider) putBlock(block *Block, blockId string, name string, token int) error {
+	s := signingRequest{}
+	s.Verb = "PUT"
+	s.ContentLength = len(block.Bytes)
+	s.ContentMD5 = contentMD5(block.Bytes)
+	s.Date = time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
+	s.Account = azure.AccountName
+	s.Container = azure.resourcePath()
+	s.FileName = strings.TrimPrefix(blobPath(name), "/")
+	s.BlockId = blockId
+	s.TypeName = "block"
+
+	target := fmt.Sprintf("%s%s?comp=block&blockid=%s", azure.endPoint(), blobPath(name), url.QueryEscape(blockId))
+	jww.TRACE.Println("target http request:", target)
+
+	body := bytes.NewReader(block.Bytes)
+	req, err := retryablehttp.NewRequest("PUT", target, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-MD5", s.ContentMD5)
+	err = azure.authorize(req, put_block_auth_header, s)
+	if err != nil {
+		return err
+	}
+
+	res, resBody, err := send(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(res, resBody)
+}
+
+// putBlob uploads the whole blob in one request. Azure checks the Content-MD5
+// and keeps it as the blob's MD5.
+func (azure *AzureProvider) putBlob(name string, data []byte) error {
+	s := signingRequest{}
+	s.Verb = "PUT"
+	s.ContentLength = len(data)
+	s.ContentMD5 = contentMD5(data)
+	s.Date = time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
+	s.Account = azure.AccountName
+	s.Container = azure.resourcePath()
+	s.FileName = strings.TrimPrefix(blobPath(name), "/")
+
+	target := fmt.Sprintf("%s%s", azure.endPoint(), blobPath(name))
+	jww.TRACE.Println("target http request:", target)
+
+	body := bytes.NewReader(data)
+	req, err := retryablehttp.NewRequest("PUT", target, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	req.Header.Set("Content-MD5", s.ContentMD5)
+	err = azure.authorize(req, put_blob_auth_header, s)
+	if err != nil {
+		return err
+	}
+
+	res, resBody, err := send(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(res, resBody)
 }
 
-func (azure *AzureProvider) putBlockList(name string, blockList []string) int {
-	datetime := time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
+// putBlockList commits the blocks in order and sets blobMD5, the base64 MD5 of
+// the whole blob, as its Content-MD5.
+func (azure *AzureProvider) putBlockList(name string, blockList []string, blobMD5 string) error {
 	bodyTemplate, err := template.New("put_block_list_body").Parse(put_block_list_body)
 	if err != nil {
-		jww.ERROR.Println("Bad put_block_list_body.tmpl", err)
-		os.Exit(1)
+		return err
 	}
 	var bodyBuilder strings.Builder
-	bodyTemplate.Execute(&bodyBuilder, blockList)
-
-	tmpl, err := template.New("put_block_list_auth_header").Parse(put_block_list_auth_header)
+	err = bodyTemplate.Execute(&bodyBuilder, blockList)
 	if err != nil {
-		jww.ERROR.Println("Bad put_block_list_auth_header.tmpl", err)
-		os.Exit(1)
+		return err
 	}
 
 	s := signingRequest{}
 	s.Verb = "PUT"
 	s.ContentLength = len(bodyBuilder.String())
-	s.Date = datetime
+	s.Date = time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
 	s.Account = azure.AccountName
-	s.Container = azure.ContainerName
-	s.FileName = strings.TrimPrefix(name, "/")
+	s.Container = azure.resourcePath()
+	s.FileName = strings.TrimPrefix(blobPath(name), "/")
 	s.TypeName = "blocklist"
+	s.BlobContentMD5 = blobMD5
 
-	var builder strings.Builder
-	tmpl.Execute(&builder, s)
+	target := fmt.Sprintf("%s%s?comp=blocklist", azure.endPoint(), blobPath(name))
+	jww.TRACE.Println("target http request:", target)
 
-	decodedKey, err := base64.StdEncoding.DecodeString(azure.Key)
+	body := strings.NewReader(bodyBuilder.String())
+	req, err := retryablehttp.NewRequest("PUT", target, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-ms-blob-content-md5", s.BlobContentMD5)
+	err = azure.authorize(req, put_block_list_auth_header, s)
 	if err != nil {
-		jww.ERROR.Println("Bad base64 key: ", err)
-		os.Exit(1)
+		return err
 	}
 
-	h := hmac.New(sha256.New, decodedKey)
-	h.Write([]byte(builder.String()))
-	authKey := fmt.Sprintf("SharedKey %s:%s", azure.AccountName, base64.StdEncoding.EncodeToString(h.Sum(nil)))
-	// Assumes name begins with '/'
-	target := fmt.Sprintf("%s%s?comp=blocklist", azure.endPoint(), name)
+	res, resBody, err := send(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(res, resBody)
+}
 
-	body := strings.NewReader(bodyBuilder.String())
-	req, err := retryablehttp.NewRequest("PUT", target, body)
+// getUncommittedBlocks returns the size of each block put to name but not yet
+// committed, keyed by block id. A blob that does not exist has none.
+func (azure *AzureProvider) getUncommittedBlocks(name string) (map[string]int, error) {
+	s := signingRequest{}
+	s.Verb = "GET"
+	s.Date = time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
+	s.Account = azure.AccountName
+	s.Container = azure.resourcePath()
+	s.FileName = strings.TrimPrefix(blobPath(name), "/")
+	s.TypeName = "blocklist"
+
+	target := fmt.Sprintf("%s%s?comp=blocklist&blocklisttype=uncommitted", azure.endPoint(), blobPath(name))
+	jww.TRACE.Println("target http request:", target)
+
+	req, err := retryablehttp.NewRequest("GET", target, nil)
 	if err != nil {
-		jww.ERROR.Println("Bad build of http request structure.", err)
-		os.Exit(1)
+		return nil, err
+	}
+	err = azure.authorize(req, get_block_list_auth_header, s)
+	if err != nil {
+		return nil, err
 	}
-	req.Header.Add("Authorization", authKey)
-	req.Header.Add("Date", datetime)
-	req.Header.Add("x-ms-version", "2017-11-09")
 
-	res, err := client.Do(req)
+	res, resBody, err := send(req)
 	if err != nil {
-		jww.ERROR.Println("bad http request.", err)
-		os.Exit(1)
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return map[string]int{}, nil
+	}
+	err = checkResponse(res, resBody)
+	if err != nil {
+		return nil, err
 	}
-	defer res.Body.Close()
 
-	resBody, _ := ioutil.ReadAll(res.Body)
-	jww.TRACE.Println(res)
-	jww.TRACE.Printf("%s\n", resBody)
+	var blockList BlockList
+	err = xml.Unmarshal(resBody, &blockList)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int, len(blockList.UncommittedBlocks))
+	for _, block := range blockList.UncommittedBlocks {
+		sizes[block.Name] = block.Size
+	}
+	return sizes, nil
+}
 
-	return res.StatusCode
+// contentMD5 is the base64 MD5 sent in Content-MD5 headers.
+func contentMD5(data []byte) string {
+	sum := md5.Sum(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
 }
 
-func makeBlockId(prefix string, count int) string {
-	id := fmt.Sprintf("%s%5d", prefix, count)
+// makeBlockId names block count of a blob after its ordinal and the MD5 of its
+// data, so an uncommitted block is only reused when its content is the same.
+// Every id has the same length, which Azure requires within a blob.
+func makeBlockId(prefix string, count int, data []byte) string {
+	id := fmt.Sprintf("%s%5d%x", prefix, count, md5.Sum(data))
 	return base64.StdEncoding.EncodeToString([]byte(id))
 }
 
-func (azure *AzureProvider) Create(name string, stream <-chan *Block, blockCount int, tokenBucket chan int) error {
+// Create puts each block of the stream and commits the block list. The first
+// failed put stops any further blocks being sent and the blob is not committed.
+// A stream whose length is within PutBlobThreshold is sent with one Put Blob.
+func (azure *AzureProvider) Create(name string, stream <-chan *Block, blockCount int, length int64, tokenBucket chan int, resume bool) error {
 	//Init (AWS)
 	//Put blocks -> fanout
 	//  Waitgroup for these on
 	//Put list/commit
 
+	if PutBlobThreshold() > 0 && length != UNKNOWN && length <= int64(PutBlobThreshold()) {
+		return azure.createBlob(name, stream, length, tokenBucket)
+	}
+
+	//Block ids depend on the ordinal and content so the uncommitted blocks of an
+	//interrupted upload with the same blockSize are reused only where the
+	//source hasn't changed
+	var uploaded map[string]int
+	if resume {
+		var err error
+		token := <-tokenBucket
+		uploaded, err = azure.getUncommittedBlocks(name)
+		azure.returnToken(tokenBucket, token)
+		if err != nil {
+			go drain(stream)
+			return fmt.Errorf("get block list of %s: %w", name, err)
+		}
+		jww.INFO.Printf("Resuming %s with %d uncommitted blocks", name, len(uploaded))
+	}
+
 	var wg sync.WaitGroup
-	idList := make([]string, blockCount)
+	var mu sync.Mutex
+	var putErr error
+	failed := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return putErr
+	}
+	var idList []string
+	if blockCount != UNKNOWN {
+		idList = make([]string, 0, blockCount)
+	}
+	skipped := 0
+	blobHash := newOrderedHash(md5.New())
+
+	//A block's buffer goes back to the pool once it is both hashed and put
+	var finishedMu sync.Mutex
+	finished := make(map[int]bool)
+	finish := func(blocks ...*Block) {
+		finishedMu.Lock()
+		defer finishedMu.Unlock()
+		for _, block := range blocks {
+			if !finished[block.Ordinal] {
+				finished[block.Ordinal] = true
+				continue
+			}
+			delete(finished, block.Ordinal)
+			PutBuffer(block.Bytes)
+		}
+	}
+	abort := func(err error) error {
+		wg.Wait()
+		finish(blobHash.held()...)
+		go drain(stream)
+		return err
+	}
 
 	for block := range stream {
+		if block.Err != nil {
+			return abort(block.Err)
+		}
+		finish(blobHash.add(block)...)
+
+		blockId := makeBlockId("stor", block.Ordinal, block.Bytes)
+		for len(idList) <= block.Ordinal {
+			idList = append(idList, "")
+		}
+		idList[block.Ordinal] = blockId
+		if size, ok := uploaded[blockId]; ok && size == len(block.Bytes) {
+			jww.INFO.Printf("Azure Provider Skipped Block[%d] already uploaded", block.Ordinal)
+			skipped++
+			finish(block)
+			continue
+		}
+
 		token := <-tokenBucket
+		if err := failed(); err != nil {
+			azure.returnToken(tokenBucket, token)
+			finish(block)
+			return abort(err)
+		}
+
 		wg.Add(1)
-		blockId := makeBlockId("stor", block.Ordinal)
-		idList[block.Ordinal] = blockId
 
 		go func(block *Block, blockId string, name string, token int) {
 			defer azure.returnToken(tokenBucket, token)
 			defer wg.Done()
+			defer finish(block)
 
-			azure.putBlock(block, blockId, name, token)
+			err := azure.putBlock(block, blockId, name, token)
+			if err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				if putErr == nil {
+					putErr = fmt.Errorf("put block %d of %s: %w", block.Ordinal, name, err)
+				}
+			}
 		}(block, blockId, name, token)
 		jww.INFO.Printf("Azure Provider Received Block[%d] with length %d", block.Ordinal, len(block.Bytes))
 	}
 
 	wg.Wait()
+	if err := failed(); err != nil {
+		return abort(err)
+	}
+	if skipped > 0 {
+		jww.INFO.Printf("Resumed %s reusing %d of %d blocks", name, skipped, len(idList))
+	}
 
-	azure.putBlockList(name, idList)
+	token := <-tokenBucket
+	defer azure.returnToken(tokenBucket, token)
+	err := azure.putBlockList(name, idList, base64.StdEncoding.EncodeToString(blobHash.Sum(nil)))
+	if err != nil {
+		return fmt.Errorf("put block list of %s: %w", name, err)
+	}
+	return nil
+}
+
+// createBlob uploads a stream of length bytes, no more than PutBlobThreshold
+// and so a single block, with one Put Blob. A stream that doesn't hold exactly
+// length bytes, e.g. from a file that changed after it was planned, is an error.
+func (azure *AzureProvider) createBlob(name string, stream <-chan *Block, length int64, tokenBucket chan int) error {
+	var data []byte
+	var received int64
+	for block := range stream {
+		if block.Err != nil {
+			PutBuffer(data)
+			go drain(stream)
+			return block.Err
+		}
+		received += int64(len(block.Bytes))
+		if data != nil || block.Ordinal != 0 {
+			PutBuffer(block.Bytes)
+			continue
+		}
+		data = block.Bytes
+	}
+	defer PutBuffer(data)
+	if received != length || int64(len(data)) != length {
+		return fmt.Errorf("put blob %s: read %d bytes of %d", name, received, length)
+	}
+
+	token := <-tokenBucket
+	defer azure.returnToken(tokenBucket, token)
 
+	jww.INFO.Printf("Azure Provider Put Blob %s with length %d", name, len(data))
+	err := azure.putBlob(name, data)
+	if err != nil {
+		return fmt.Errorf("put blob %s: %w", name, err)
+	}
 	return nil
 }
 
@@ -321,91 +901,350 @@ func (azure *AzureProvider) ProviderName() string {
 	return "azure"
 }
 
+// getBlock fetches the inclusive byte range [start, end] of the blob with
+// x-ms-range straight into buf, which must be large enough for it. A body
+// shorter than its Content-Length is an error.
+func (azure *AzureProvider) getBlock(name string, start int64, end int64, buf []byte) ([]byte, error) {
+	s := signingRequest{}
+	s.Verb = "GET"
+	s.Date = time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
+	s.Account = azure.AccountName
+	s.Container = azure.resourcePath()
+	s.FileName = strings.TrimPrefix(blobPath(name), "/")
+	s.MsRange = fmt.Sprintf("bytes=%d-%d", start, end)
+
+	target := fmt.Sprintf("%s%s", azure.endPoint(), blobPath(name))
+	jww.TRACE.Println("target http request:", target, s.MsRange)
+
+	req, err := retryablehttp.NewRequest("GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("x-ms-range", s.MsRange)
+	err = azure.authorize(req, get_blob_auth_header, s)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	jww.TRACE.Println(res)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		resBody, _ := ioutil.ReadAll(res.Body)
+		return nil, checkResponse(res, resBody)
+	}
+	if res.ContentLength < 0 || res.ContentLength > int64(len(buf)) {
+		return nil, fmt.Errorf("get %s %s: Content-Length %d for a %d byte block", name, s.MsRange, res.ContentLength, len(buf))
+	}
+	n, err := io.ReadFull(res.Body, buf[:res.ContentLength])
+	if err != nil {
+		return nil, fmt.Errorf("get %s %s: read %d of %d bytes: %w", name, s.MsRange, n, res.ContentLength, err)
+	}
+	return buf[:n], nil
+}
+
 func (azure *AzureProvider) Open(name string, stream chan<- *Block, tokenBucket chan int, blockCount int, blockSize int) error {
+	return azure.OpenRange(name, stream, tokenBucket, 0, int64(blockCount)*int64(blockSize), blockSize)
+}
+
+// OpenRange fetches length bytes of the blob from start with a ranged GET per
+// block. Ordinals count from start. A range past the end of the blob comes back short.
+func (azure *AzureProvider) OpenRange(name string, stream chan<- *Block, tokenBucket chan int, start int64, length int64, blockSize int) error {
+	blockCount := int((length + int64(blockSize) - 1) / int64(blockSize))
+	jww.INFO.Printf("Open azure blob: %s from %d with %d blocks of %d size", name, start, blockCount, blockSize)
+
+	go func() {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var getErr error
+		failed := func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return getErr != nil
+		}
+
+		for i := 0; i < blockCount && !failed(); i++ {
+			//Buffers are taken in order so the blocks the writer waits on come first
+			buf := GetBuffer(blockSize)
+			token := <-tokenBucket
+			wg.Add(1)
+
+			go func(ordinal int, buf []byte, token int) {
+				defer wg.Done()
+
+				offset := start + int64(ordinal)*int64(blockSize)
+				end := offset + int64(blockSize)
+				if end > start+length {
+					end = start + length
+				}
+				data, err := azure.getBlock(name, offset, end-1, buf)
+				azure.returnToken(tokenBucket, token)
+				if err != nil {
+					PutBuffer(buf)
+					mu.Lock()
+					defer mu.Unlock()
+					if getErr == nil {
+						getErr = err
+					}
+					return
+				}
+
+				jww.INFO.Printf("Azure Provider Read Block[%d] with length %d", ordinal, len(data))
+				stream <- &Block{Bytes: data, Ordinal: ordinal}
+			}(i, buf, token)
+		}
+
+		wg.Wait()
+		if getErr != nil {
+			stream <- &Block{Err: getErr}
+		}
+		close(stream)
+	}()
+
 	return nil
 }
 
-func (azure *AzureProvider) Stat(name string) *BlobInfo {
-	//There are no directories actually in blob stores
+// Stat fetches the blob's properties with Get Blob Properties. A name that is not
+// a blob but is a prefix of other blobs followed by '/' is answered as a virtual
+// directory since there are no directories actually in blob stores.
+func (azure *AzureProvider) Stat(name string) (*BlobInfo, error) {
+	blobName := strings.TrimPrefix(name, "/")
+	if blobName == "" || strings.HasSuffix(blobName, "/") {
+		return azure.statDir(blobName)
+	}
+
+	s := signingRequest{}
+	s.Verb = "HEAD"
+	s.Date = time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
+	s.Account = azure.AccountName
+	s.Container = azure.resourcePath()
+	s.FileName = strings.TrimPrefix(blobPath(name), "/")
+
+	target := fmt.Sprintf("%s%s", azure.endPoint(), blobPath(name))
+	jww.TRACE.Println("target http request:", target)
+
+	req, err := retryablehttp.NewRequest("HEAD", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = azure.authorize(req, blob_auth_header, s)
+	if err != nil {
+		return nil, err
+	}
+
+	res, resBody, err := send(req)
+	if err != nil {
+		return nil, err
+	}
+	err = checkResponse(res, resBody)
+	if KindOf(err) == NotFound {
+		return azure.statDir(blobName + "/")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blobInfo := &BlobInfo{}
+	blobInfo.Name = blobName
+	blobInfo.PathName = blobName
+	blobInfo.Length = res.ContentLength
+	blobInfo.Etag = res.Header.Get("ETag")
+	blobInfo.LastModified, _ = http.ParseTime(res.Header.Get("Last-Modified"))
+	blobInfo.CreatedAt, _ = http.ParseTime(res.Header.Get("x-ms-creation-time"))
+	blobInfo.Type = res.Header.Get("Content-Type")
+	blobInfo.Encoding = res.Header.Get("Content-Encoding")
+	blobInfo.MD5 = res.Header.Get("Content-MD5")
+	blobInfo.BlobType = res.Header.Get("x-ms-blob-type")
+	blobInfo.AccessTier = res.Header.Get("x-ms-access-tier")
+	blobInfo.LeaseState = res.Header.Get("x-ms-lease-state")
+	blobInfo.LeaseStatus = res.Header.Get("x-ms-lease-status")
+	blobInfo.Metadata = make(map[string]string)
+	for key := range res.Header {
+		lowerKey := strings.ToLower(key)
+		if strings.HasPrefix(lowerKey, "x-ms-meta-") {
+			blobInfo.Metadata[strings.TrimPrefix(lowerKey, "x-ms-meta-")] = res.Header.Get(key)
+		}
+	}
+	return blobInfo, nil
+}
+
+// statDir answers Stat for a virtual directory, which exists when at least one blob has prefix.
+func (azure *AzureProvider) statDir(prefix string) (*BlobInfo, error) {
+	if prefix != "" {
+		results, err := azure.listBlobs(prefix, "", 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(results.Blobs) == 0 {
+			return nil, &Error{Kind: NotFound, Message: "no blob or virtual directory named " + strings.TrimSuffix(prefix, "/")}
+		}
+	}
+
 	blobInfo := &BlobInfo{}
-	blobInfo.IsDir = false
-	return blobInfo
+	blobInfo.Name = prefix
+	blobInfo.PathName = prefix
+	blobInfo.IsDir = true
+	return blobInfo, nil
 }
 
-func (azure *AzureProvider) Glob(pattern string) []*BlobInfo {
-	datetime := time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
-	tmpl, err := template.New("get_blob_list_auth_header").Parse(get_blob_list_auth_header)
+// Delete removes the blob with Delete Blob.
+func (azure *AzureProvider) Delete(name string) error {
+	s := signingRequest{}
+	s.Verb = "DELETE"
+	s.Date = time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
+	s.Account = azure.AccountName
+	s.Container = azure.resourcePath()
+	s.FileName = strings.TrimPrefix(blobPath(name), "/")
+
+	target := fmt.Sprintf("%s%s", azure.endPoint(), blobPath(name))
+	jww.TRACE.Println("target http request:", target)
+
+	req, err := retryablehttp.NewRequest("DELETE", target, nil)
+	if err != nil {
+		return err
+	}
+	err = azure.authorize(req, blob_auth_header, s)
 	if err != nil {
-		jww.ERROR.Println("bad tmpl")
-		jww.ERROR.Println(err)
+		return err
 	}
 
+	res, resBody, err := send(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(res, resBody)
+}
+
+// globPrefix splits a shell style pattern into the literal prefix before the
+// first wildcard, which Azure can match server side, and whether any wildcard remains.
+func globPrefix(pattern string) (string, bool) {
+	i := strings.IndexAny(pattern, "*?[\\")
+	if i < 0 {
+		return pattern, false
+	}
+	return pattern[:i], true
+}
+
+// listBlobs fetches one page of the List Blobs results starting at marker.
+func (azure *AzureProvider) listBlobs(prefix string, marker string, maxResults int) (*EnumerationResults, error) {
 	s := signingRequest{}
 	s.Verb = "GET"
 	s.ContentLength = 0
-	s.Date = datetime
+	s.Date = time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
 	s.Account = azure.AccountName
-	s.Container = azure.ContainerName
+	s.Container = azure.resourcePath()
 	s.TypeName = "container"
+	s.Prefix = prefix
+	s.Marker = marker
+	s.MaxResults = maxResults
 
-	var builder strings.Builder
-	tmpl.Execute(&builder, s)
-
-	decodedKey, err := base64.StdEncoding.DecodeString(azure.Key)
-	if err != nil {
-		jww.ERROR.Println("Bad base64 key: ", err)
-		os.Exit(1)
+	query := url.Values{}
+	query.Set("restype", "container")
+	query.Set("comp", "list")
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if marker != "" {
+		query.Set("marker", marker)
+	}
+	if maxResults > 0 {
+		query.Set("maxresults", strconv.Itoa(maxResults))
 	}
 
-	h := hmac.New(sha256.New, decodedKey)
-	h.Write([]byte(builder.String()))
-	authKey := fmt.Sprintf("SharedKey %s:%s", azure.AccountName, base64.StdEncoding.EncodeToString(h.Sum(nil)))
-
-	target := fmt.Sprintf("%s?restype=container&comp=list", azure.endPoint())
+	target := fmt.Sprintf("%s?%s", azure.endPoint(), query.Encode())
 	jww.TRACE.Println(target)
 
 	req, err := retryablehttp.NewRequest("GET", target, nil)
 	if err != nil {
-		jww.ERROR.Println("Bad build of http request structure.", err)
-		os.Exit(1)
+		return nil, err
 	}
-	req.Header.Add("Authorization", authKey)
-	req.Header.Add("Date", datetime)
-	req.Header.Add("x-ms-version", "2017-11-09")
-
-	res, err := client.Do(req)
+	err = azure.authorize(req, get_blob_list_auth_header, s)
 	if err != nil {
-		jww.ERROR.Println("bad http request.", err)
-		os.Exit(1)
+		return nil, err
 	}
-	defer res.Body.Close()
-
-	var results EnumerationResults
 
-	resBody, err := ioutil.ReadAll(res.Body)
+	res, resBody, err := send(req)
 	if err != nil {
-		jww.ERROR.Println(err)
-		os.Exit(1)
+		return nil, err
+	}
+	err = checkResponse(res, resBody)
+	if err != nil {
+		return nil, err
 	}
 
+	var results EnumerationResults
 	err = xml.Unmarshal(resBody, &results)
 	if err != nil {
-		jww.ERROR.Println(err)
-		os.Exit(1)
+		return nil, err
 	}
+	return &results, nil
+}
+
+// Walk calls walkFn for each blob whose name begins with pattern as the pages of
+// the listing arrive. Shell style wildcards after the literal prefix are matched
+// client side with path.Match semantics. The page size is the maxresults setting.
+func (azure *AzureProvider) Walk(pattern string, walkFn WalkFunc) error {
+	pattern = strings.TrimPrefix(pattern, "/")
+	prefix, wild := globPrefix(pattern)
+	maxResults := viper.GetInt("maxresults")
+	jww.TRACE.Printf("Walk pattern: %s prefix: %s maxresults: %d", pattern, prefix, maxResults)
+
 	var layout string = "Mon, 02 Jan 2006 15:04:05 MST"
-	matches := make([]*BlobInfo, len(results.Blobs))
-	for i, blob := range results.Blobs {
-		var blobInfo *BlobInfo = &BlobInfo{}
-		blobInfo.Name = blob.Name
-		blobInfo.PathName = blob.Name
-		blobInfo.Length = blob.ContentLength
-		blobInfo.CreatedAt, _ = time.Parse(layout, blob.CreationTime)
-		blobInfo.LastModified, _ = time.Parse(layout, blob.LastModified)
-		blobInfo.MD5 = blob.ContentMD5
-		blobInfo.Etag = blob.Etag
-		blobInfo.BlobType = blob.BlobType
-		matches[i] = blobInfo
-	}
-	return matches
+	marker := ""
+	for {
+		results, err := azure.listBlobs(prefix, marker, maxResults)
+		if err != nil {
+			return err
+		}
+		jww.INFO.Printf("Listed page of %d blobs. NextMarker: %s", len(results.Blobs), results.NextMarker)
+
+		for _, blob := range results.Blobs {
+			if wild {
+				matched, err := path.Match(pattern, blob.Name)
+				if err != nil {
+					return &Error{Kind: Other, Message: "bad glob pattern " + pattern, Err: err}
+				}
+				if !matched {
+					continue
+				}
+			}
+			var blobInfo *BlobInfo = &BlobInfo{}
+			blobInfo.Name = blob.Name
+			blobInfo.PathName = blob.Name
+			blobInfo.Length = blob.ContentLength
+			blobInfo.CreatedAt, _ = time.Parse(layout, blob.CreationTime)
+			blobInfo.LastModified, _ = time.Parse(layout, blob.LastModified)
+			blobInfo.MD5 = blob.ContentMD5
+			blobInfo.Etag = blob.Etag
+			blobInfo.Type = blob.ContentType
+			blobInfo.Encoding = blob.ContentEncoding
+			blobInfo.BlobType = blob.BlobType
+			blobInfo.AccessTier = blob.AccessTier
+			blobInfo.LeaseState = blob.LeaseState
+			blobInfo.LeaseStatus = blob.LeaseStatus
+			err := walkFn(blobInfo)
+			if err != nil {
+				return err
+			}
+		}
+
+		if results.NextMarker == "" {
+			return nil
+		}
+		marker = results.NextMarker
+	}
+}
+
+// Glob collects every blob Walk finds for pattern.
+func (azure *AzureProvider) Glob(pattern string) ([]*BlobInfo, error) {
+	var matches []*BlobInfo
+	err := azure.Walk(pattern, func(blobInfo *BlobInfo) error {
+		matches = append(matches, blobInfo)
+		return nil
+	})
+	return matches, err
 }
@@ -0,0 +1,556 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestBlobPath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"logs/a.txt", "/logs/a.txt"},
+		{"/logs/a.txt", "/logs/a.txt"},
+		{"logs/x#y", "/logs/x%23y"},
+		{"logs/a?b", "/logs/a%3Fb"},
+		{"logs/100%", "/logs/100%25"},
+		{"my logs/a b", "/my%20logs/a%20b"},
+	}
+	for _, test := range tests {
+		if got := blobPath(test.name); got != test.want {
+			t.Errorf("blobPath(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// Names with '#', '?' and '%' must reach the service whole. SAS requests aren't
+// signed so nothing else would catch a truncated path.
+func TestRequestsKeepEscapedNames(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	azure := &AzureProvider{
+		AccountName:   "acct",
+		ContainerName: "cont",
+		SAS:           "sv=2017-11-09&sig=test",
+		Endpoint:      server.URL + "/acct",
+	}
+
+	names := []string{"logs/x#y", "logs/a?b", "logs/100%", "/logs/x y"}
+	for _, name := range names {
+		err := azure.Delete(name)
+		if err != nil {
+			t.Fatalf("Delete(%q): %v", name, err)
+		}
+	}
+
+	want := []string{"/acct/cont/logs/x#y", "/acct/cont/logs/a?b", "/acct/cont/logs/100%", "/acct/cont/logs/x y"}
+	if len(paths) != len(want) {
+		t.Fatalf("got %d requests, want %d", len(paths), len(want))
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("Delete(%q) requested %q, want %q", names[i], paths[i], want[i])
+		}
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		header     string
+		body       string
+		want       *Error
+	}{
+		{http.StatusOK, "", "", nil},
+		{http.StatusCreated, "", "", nil},
+		{http.StatusPartialContent, "", "", nil},
+		{
+			http.StatusNotFound, "BlobNotFound",
+			"<?xml version=\"1.0\" encoding=\"utf-8\"?><Error><Code>BlobNotFound</Code><Message>The specified blob does not exist.\nRequestId:1234\nTime:2018-01-01T00:00:00.0000000Z</Message></Error>",
+			&Error{Kind: NotFound, StatusCode: 404, Code: "BlobNotFound", Message: "The specified blob does not exist."},
+		},
+		{
+			http.StatusForbidden, "",
+			"<?xml version=\"1.0\" encoding=\"utf-8\"?><Error><Code>AuthenticationFailed</Code><Message>Server failed to authenticate the request.\nRequestId:1234</Message><AuthenticationErrorDetail>Signature did not match.</AuthenticationErrorDetail></Error>",
+			&Error{Kind: AuthFailed, StatusCode: 403, Code: "AuthenticationFailed", Message: "Server failed to authenticate the request."},
+		},
+		// HEAD responses only have the header
+		{http.StatusNotFound, "ContainerNotFound", "", &Error{Kind: NotFound, StatusCode: 404, Code: "ContainerNotFound"}},
+		{http.StatusServiceUnavailable, "ServerBusy", "", &Error{Kind: Throttled, StatusCode: 503, Code: "ServerBusy"}},
+		{http.StatusConflict, "", "not xml", &Error{Kind: Conflict, StatusCode: 409}},
+		{http.StatusInternalServerError, "", "", &Error{Kind: Other, StatusCode: 500}},
+	}
+	for _, test := range tests {
+		res := &http.Response{StatusCode: test.statusCode, Header: make(http.Header)}
+		if test.header != "" {
+			res.Header.Set("x-ms-error-code", test.header)
+		}
+		err := checkResponse(res, []byte(test.body))
+		if test.want == nil {
+			if err != nil {
+				t.Errorf("status %d: got %v, want no error", test.statusCode, err)
+			}
+			continue
+		}
+		var got *Error
+		if !errors.As(err, &got) {
+			t.Errorf("status %d: %v is not an *Error", test.statusCode, err)
+			continue
+		}
+		if *got != *test.want {
+			t.Errorf("status %d: got %+v, want %+v", test.statusCode, *got, *test.want)
+		}
+	}
+}
+
+func TestApplyConnectionString(t *testing.T) {
+	tests := []struct {
+		set     AzureProvider
+		conn    string
+		want    AzureProvider
+		wantErr bool
+	}{
+		{
+			conn: "DefaultEndpointsProtocol=https;AccountName=acct;AccountKey=a2V5==;EndpointSuffix=core.windows.net",
+			want: AzureProvider{AccountName: "acct", Key: "a2V5==", Endpoint: "https://acct.blob.core.windows.net"},
+		},
+		{
+			conn: " accountname = acct ; accountkey = a2V5== ; endpointsuffix = core.chinacloudapi.cn ;",
+			want: AzureProvider{AccountName: "acct", Key: "a2V5==", Endpoint: "https://acct.blob.core.chinacloudapi.cn"},
+		},
+		{
+			conn: "DefaultEndpointsProtocol=http;AccountName=acct;AccountKey=a2V5==",
+			want: AzureProvider{AccountName: "acct", Key: "a2V5==", Endpoint: "http://acct.blob.core.windows.net"},
+		},
+		{
+			conn: "BlobEndpoint=https://acct.blob.core.windows.net/;SharedAccessSignature=?sv=2017-11-09&sig=abc%3D",
+			want: AzureProvider{SAS: "sv=2017-11-09&sig=abc%3D", Endpoint: "https://acct.blob.core.windows.net"},
+		},
+		{
+			conn: "UseDevelopmentStorage=true",
+			want: AzureProvider{AccountName: DEV_STORAGE_ACCOUNT, Key: DEV_STORAGE_KEY, Endpoint: DEV_STORAGE_ENDPOINT},
+		},
+		{
+			conn: "UseDevelopmentStorage=true;BlobEndpoint=http://azurite:10000/devstoreaccount1",
+			want: AzureProvider{AccountName: DEV_STORAGE_ACCOUNT, Key: DEV_STORAGE_KEY, Endpoint: "http://azurite:10000/devstoreaccount1"},
+		},
+		{
+			// Fields set on the alias win over the connection string
+			set:  AzureProvider{AccountName: "mine", Endpoint: "http://127.0.0.1:10000/mine"},
+			conn: "AccountName=acct;AccountKey=a2V5==;EndpointSuffix=core.windows.net",
+			want: AzureProvider{AccountName: "mine", Key: "a2V5==", Endpoint: "http://127.0.0.1:10000/mine"},
+		},
+		{
+			conn: "AccountName=acct;AccountKey=a2V5==",
+			want: AzureProvider{AccountName: "acct", Key: "a2V5=="},
+		},
+		{conn: "EndpointSuffix=core.windows.net;AccountKey=a2V5==", wantErr: true},
+		{conn: "AccountName=acct;AccountKey", wantErr: true},
+		{conn: "=acct", wantErr: true},
+	}
+	for _, test := range tests {
+		azure := test.set
+		err := azure.applyConnectionString(test.conn)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: got %+v, want an error", test.conn, azure)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.conn, err)
+			continue
+		}
+		if azure != test.want {
+			t.Errorf("%q: got %+v, want %+v", test.conn, azure, test.want)
+		}
+	}
+}
+
+func TestSplitServiceURL(t *testing.T) {
+	tests := []struct {
+		url       string
+		endpoint  string
+		account   string
+		container string
+	}{
+		{"https://acct.blob.core.windows.net/cont", "https://acct.blob.core.windows.net", "acct", "cont"},
+		{"https://acct.blob.core.windows.net/cont/", "https://acct.blob.core.windows.net", "acct", "cont"},
+		{"https://acct.blob.core.windows.net", "https://acct.blob.core.windows.net", "acct", ""},
+		{"https://acct.blob.core.chinacloudapi.cn:443/cont", "https://acct.blob.core.chinacloudapi.cn:443", "acct", "cont"},
+		{"http://127.0.0.1:10000/devstoreaccount1/cont", "http://127.0.0.1:10000/devstoreaccount1", "devstoreaccount1", "cont"},
+		{"http://127.0.0.1:10000/devstoreaccount1", "http://127.0.0.1:10000/devstoreaccount1", "devstoreaccount1", ""},
+		{"http://azurite:10000/acct/cont/", "http://azurite:10000/acct", "acct", "cont"},
+	}
+	for _, test := range tests {
+		serviceURL, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoint, account, container := splitServiceURL(serviceURL)
+		if endpoint != test.endpoint || account != test.account || container != test.container {
+			t.Errorf("splitServiceURL(%s) = %q, %q, %q, want %q, %q, %q", test.url,
+				endpoint, account, container, test.endpoint, test.account, test.container)
+		}
+	}
+}
+
+// fastRetries shortens the client's backoff for the length of a test.
+func fastRetries() func() {
+	waitMin, waitMax := client.RetryWaitMin, client.RetryWaitMax
+	client.RetryWaitMin, client.RetryWaitMax = time.Millisecond, 5*time.Millisecond
+	return func() { client.RetryWaitMin, client.RetryWaitMax = waitMin, waitMax }
+}
+
+// Once retries run out on a busy server the last response decides the error,
+// so a throttled request is reported as Throttled.
+func TestThrottledAfterRetries(t *testing.T) {
+	defer fastRetries()()
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		w.Header().Set("x-ms-error-code", "ServerBusy")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("<?xml version=\"1.0\" encoding=\"utf-8\"?><Error><Code>ServerBusy</Code><Message>Ingress is over the account limit.\nRequestId:1234</Message></Error>"))
+	}))
+	defer server.Close()
+
+	azure := &AzureProvider{
+		AccountName:   "acct",
+		ContainerName: "cont",
+		SAS:           "sv=2017-11-09&sig=test",
+		Endpoint:      server.URL + "/acct",
+	}
+	err := azure.Delete("logs/a.txt")
+	var azureErr *Error
+	if !errors.As(err, &azureErr) {
+		t.Fatalf("Delete error %v is not an *Error", err)
+	}
+	if azureErr.Kind != Throttled || azureErr.StatusCode != http.StatusServiceUnavailable || azureErr.Code != "ServerBusy" {
+		t.Errorf("got %v %d %s, want throttled 503 ServerBusy", azureErr.Kind, azureErr.StatusCode, azureErr.Code)
+	}
+	if requests != client.RetryMax+1 {
+		t.Errorf("%d requests, want %d", requests, client.RetryMax+1)
+	}
+}
+
+// Errors end up on stderr and in the journal so they must not carry the SAS.
+func TestErrorsLeaveOutSAS(t *testing.T) {
+	defer fastRetries()()
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	azure := &AzureProvider{
+		AccountName:   "acct",
+		ContainerName: "cont",
+		SAS:           "sv=2017-11-09&sig=secret",
+		Endpoint:      server.URL + "/acct",
+	}
+	_, statErr := azure.Stat("logs/a.txt")
+	deleteErr := azure.Delete("logs/a.txt")
+	_, listErr := azure.Glob("logs/")
+	for _, err := range []error{statErr, deleteErr, listErr} {
+		if err == nil {
+			t.Fatal("a request to a closed server succeeded")
+		}
+		if strings.Contains(err.Error(), "sig=") {
+			t.Errorf("error %q has the SAS signature", err)
+		}
+	}
+}
+
+// writeSource writes length random bytes from seed to name in dir.
+func writeSource(t *testing.T, dir string, name string, length int, seed int64) []byte {
+	t.Helper()
+	data := make([]byte, length)
+	rand.New(rand.NewSource(seed)).Read(data)
+	err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// A resumed upload puts only the blocks that didn't make it the first time,
+// unless the source has since been rewritten, when none of them can be reused.
+func TestCreateResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stor-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "source")
+
+	tests := []struct {
+		rewrite bool
+		puts    int
+	}{
+		{false, 4},
+		{true, 7},
+	}
+	for _, test := range tests {
+		fake := newFakeBlobs()
+		azure := fake.provider()
+
+		data := writeSource(t, dir, "source", 6*BlockSize()+100, 1)
+		fake.blockLimit = 3
+		err = copyWith(&FileProvider{}, source, azure, "/copies/source", false)
+		if err == nil {
+			t.Fatal("an upload with failing blocks succeeded")
+		}
+		if test.rewrite {
+			//Same size, different content
+			data = writeSource(t, dir, "source", len(data), 2)
+		}
+
+		fake.blockLimit = 0
+		fake.putBlocks = 0
+		err = copyWith(&FileProvider{}, source, azure, "/copies/source", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fake.putBlocks != test.puts {
+			t.Errorf("rewrite %v: resume put %d blocks, want %d", test.rewrite, fake.putBlocks, test.puts)
+		}
+		uploaded, _ := fake.blob("copies/source")
+		if !bytes.Equal(uploaded, data) {
+			t.Errorf("rewrite %v: the resumed blob isn't the source", test.rewrite)
+		}
+		fake.Close()
+	}
+}
+
+// A ranged GET whose body stops short of its Content-Length fails the read
+// rather than handing on a short block, and every buffer goes back to the pool.
+func TestGetBlockShortBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(make([]byte, 50))
+	}))
+	defer server.Close()
+
+	azure := &AzureProvider{
+		AccountName:   "acct",
+		ContainerName: "cont",
+		SAS:           "sv=2017-11-09&sig=test",
+		Endpoint:      server.URL + "/acct",
+	}
+	stream := make(chan *Block, 1)
+	err := azure.OpenRange("/a", stream, InitTokenBucket(), 0, 100, BlockSize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var readErr error
+	for block := range stream {
+		if block.Err != nil {
+			readErr = block.Err
+		}
+		PutBuffer(block.Bytes)
+	}
+	if readErr == nil {
+		t.Error("a short body was read as a block")
+	}
+	buffersReturned(t)
+}
+
+// A failed Put Block fails the upload with the kind of the failure and the
+// block list is never committed.
+func TestCreateStopsOnFailedBlock(t *testing.T) {
+	fake := newFakeBlobs()
+	defer fake.Close()
+	azure := fake.provider()
+
+	dir, err := ioutil.TempDir("", "stor-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSource(t, dir, "source", 6*BlockSize()+100, 1)
+
+	fake.blockLimit = 2
+	err = copyWith(&FileProvider{}, filepath.Join(dir, "source"), azure, "/copies/source", false)
+	if KindOf(err) != AuthFailed {
+		t.Errorf("got error %v, want authentication failed", err)
+	}
+	if fake.blockLists != 0 {
+		t.Errorf("%d block lists were committed", fake.blockLists)
+	}
+	if _, ok := fake.blob("copies/source"); ok {
+		t.Error("the blob was created")
+	}
+	buffersReturned(t)
+}
+
+// Walk follows NextMarker until the listing is complete and filters each page
+// with the pattern.
+func TestWalkPages(t *testing.T) {
+	viper.Set("maxresults", 2)
+	defer viper.Set("maxresults", 0)
+
+	fake := newFakeBlobs()
+	defer fake.Close()
+	azure := fake.provider()
+	for _, name := range []string{"logs/1.csv", "logs/2.txt", "logs/3.csv", "logs/4.csv", "logs/5.txt", "other/6.csv"} {
+		fake.blobs["/acct/cont/"+name] = []byte(name)
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+		pages   int
+	}{
+		{"/logs/", []string{"logs/1.csv", "logs/2.txt", "logs/3.csv", "logs/4.csv", "logs/5.txt"}, 3},
+		{"/logs/*.csv", []string{"logs/1.csv", "logs/3.csv", "logs/4.csv"}, 3},
+		{"/", []string{"logs/1.csv", "logs/2.txt", "logs/3.csv", "logs/4.csv", "logs/5.txt", "other/6.csv"}, 3},
+		{"/other/", []string{"other/6.csv"}, 1},
+		{"/none/", nil, 1},
+	}
+	for _, test := range tests {
+		fake.listPages = 0
+		var got []string
+		err := azure.Walk(test.pattern, func(blobInfo *BlobInfo) error {
+			got = append(got, blobInfo.PathName)
+			if blobInfo.Length != int64(len(blobInfo.PathName)) || blobInfo.MD5 != contentMD5([]byte(blobInfo.PathName)) {
+				t.Errorf("%s listed with length %d and MD5 %s", blobInfo.PathName, blobInfo.Length, blobInfo.MD5)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("Walk(%s) found %v, want %v", test.pattern, got, test.want)
+		}
+		if fake.listPages != test.pages {
+			t.Errorf("Walk(%s) listed %d pages, want %d", test.pattern, fake.listPages, test.pages)
+		}
+	}
+}
+
+// Stat maps the Get Blob Properties headers onto BlobInfo.
+func TestStatProperties(t *testing.T) {
+	fake := newFakeBlobs()
+	defer fake.Close()
+	azure := fake.provider()
+
+	fake.blobs["/acct/cont/logs/a.txt"] = []byte("hello")
+	fake.headers["/acct/cont/logs/a.txt"] = http.Header{
+		"Etag":               {`"0x8D5"`},
+		"Last-Modified":      {"Mon, 02 Jan 2006 15:04:05 GMT"},
+		"X-Ms-Creation-Time": {"Sun, 01 Jan 2006 10:00:00 GMT"},
+		"Content-Type":       {"text/plain"},
+		"Content-Encoding":   {"gzip"},
+		"Content-Md5":        {"XUFAKrxLKna5cZ2REBfFkg=="},
+		"X-Ms-Access-Tier":   {"Cool"},
+		"X-Ms-Lease-State":   {"leased"},
+		"X-Ms-Lease-Status":  {"locked"},
+		"X-Ms-Meta-Owner":    {"hays"},
+		"X-Ms-Meta-Project":  {"stor"},
+	}
+
+	info, err := azure.Stat("/logs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &BlobInfo{
+		Name:         "logs/a.txt",
+		PathName:     "logs/a.txt",
+		CreatedAt:    time.Date(2006, 1, 1, 10, 0, 0, 0, time.UTC),
+		LastModified: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+		Length:       5,
+		Etag:         `"0x8D5"`,
+		Encoding:     "gzip",
+		Type:         "text/plain",
+		MD5:          "XUFAKrxLKna5cZ2REBfFkg==",
+		BlobType:     "BlockBlob",
+		AccessTier:   "Cool",
+		LeaseState:   "leased",
+		LeaseStatus:  "locked",
+		Metadata:     map[string]string{"owner": "hays", "project": "stor"},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("Stat got\n%+v\nwant\n%+v", info, want)
+	}
+}
+
+// A name that isn't a blob is a virtual directory when blobs begin with it and
+// a / and not found otherwise.
+func TestStatVirtualDirectory(t *testing.T) {
+	fake := newFakeBlobs()
+	defer fake.Close()
+	azure := fake.provider()
+	fake.blobs["/acct/cont/logs/2024/a.txt"] = []byte("a")
+	fake.blobs["/acct/cont/logsbook"] = []byte("b")
+
+	tests := []struct {
+		name     string
+		isDir    bool
+		notFound bool
+	}{
+		{"/logs", true, false},
+		{"/logs/", true, false},
+		{"/logs/2024", true, false},
+		{"/logsbook", false, false},
+		{"/", true, false},
+		{"/log", false, true},
+		{"/logs/2023", false, true},
+		{"/missing/", false, true},
+	}
+	for _, test := range tests {
+		info, err := azure.Stat(test.name)
+		if test.notFound {
+			if KindOf(err) != NotFound {
+				t.Errorf("Stat(%s) = %+v, %v, want not found", test.name, info, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Stat(%s): %v", test.name, err)
+			continue
+		}
+		if info.IsDir != test.isDir {
+			t.Errorf("Stat(%s) IsDir = %v, want %v", test.name, info.IsDir, test.isDir)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"sync"
+
+	jww "github.com/spf13/jwalterweatherman"
+	"github.com/spf13/viper"
+)
+
+// bufferPool hands out the buffers blocks are read into and caps the bytes
+// held by blocks in flight at max_memory. A reader asking for more than is
+// left waits until blocks are written or put and their buffers come back,
+// which is the backpressure that keeps a fast reader from outrunning a slow
+// writer. Buffers are handed out in the order they were asked for. Buffers of
+// blockSize are reused rather than left to the collector.
+type bufferPool struct {
+	max   int64
+	size  int
+	pool  sync.Pool
+	mu    sync.Mutex
+	freed *sync.Cond
+	inUse int64
+	// next is the ticket of the next GetBuffer and serving the one whose turn it is.
+	next    uint64
+	serving uint64
+}
+
+var buffers *bufferPool
+var buffersOnce sync.Once
+
+// MaxMemory is the max_memory setting or, by default, enough for two blocks per
+// request in flight so reading can stay ahead of the requests.
+func MaxMemory() int64 {
+	maxMemory := viper.GetInt64("max_memory")
+	if maxMemory <= 0 {
+		maxMemory = 2 * int64(Concurrency()) * int64(BlockSize())
+	}
+	if maxMemory < int64(BlockSize()) {
+		maxMemory = int64(BlockSize())
+	}
+	return maxMemory
+}
+
+// getBufferPool makes the pool from the config the first time. InitTokenBucket
+// calls it before any reader starts so the config is only read from one goroutine.
+func getBufferPool() *bufferPool {
+	buffersOnce.Do(func() {
+		buffers = &bufferPool{max: MaxMemory(), size: BlockSize()}
+		buffers.freed = sync.NewCond(&buffers.mu)
+		jww.TRACE.Println("Max memory for blocks in flight:", buffers.max)
+	})
+	return buffers
+}
+
+// GetBuffer waits its turn and until size bytes fit under max_memory and
+// returns a buffer of that length. Later requests queue behind one that is
+// waiting, however small, so a large buffer isn't starved by a stream of small
+// ones. A buffer larger than max_memory is let through once nothing else is
+// held so it can't wait forever.
+func GetBuffer(size int) []byte {
+	bp := getBufferPool()
+	capacity := size
+	if size < bp.size {
+		capacity = bp.size
+	}
+
+	bp.mu.Lock()
+	ticket := bp.next
+	bp.next++
+	for ticket != bp.serving || (bp.inUse > 0 && bp.inUse+int64(capacity) > bp.max) {
+		bp.freed.Wait()
+	}
+	bp.serving++
+	bp.inUse += int64(capacity)
+	bp.mu.Unlock()
+	bp.freed.Broadcast()
+
+	if capacity == bp.size {
+		if buf, ok := bp.pool.Get().([]byte); ok {
+			return buf[:size]
+		}
+	}
+	return make([]byte, size, capacity)
+}
+
+// PutBuffer returns a buffer from GetBuffer once its block is done with.
+func PutBuffer(buf []byte) {
+	if buf == nil {
+		return
+	}
+	bp := getBufferPool()
+
+	bp.mu.Lock()
+	bp.inUse -= int64(cap(buf))
+	bp.mu.Unlock()
+	bp.freed.Broadcast()
+
+	if cap(buf) == bp.size {
+		bp.pool.Put(buf[:0])
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// copyWith streams name from source to targetName on target as cp does.
+func copyWith(source Provider, name string, target Provider, targetName string, resume bool) error {
+	tokenBucket := InitTokenBucket()
+	info, err := source.Stat(name)
+	if err != nil {
+		return err
+	}
+	blockCount, blockSize, err := CalculateBlocks(info)
+	if err != nil {
+		return err
+	}
+	stream := make(chan *Block, blockCount)
+	err = source.Open(name, stream, tokenBucket, blockCount, blockSize)
+	if err != nil {
+		return err
+	}
+	return target.Create(targetName, stream, blockCount, info.Length, tokenBucket, resume)
+}
+
+// copyThrough is copyWith that fails the test on any error.
+func copyThrough(t *testing.T, source Provider, name string, target Provider, targetName string) {
+	t.Helper()
+	err := copyWith(source, name, target, targetName, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A round trip through the fake blob service, run with -race, covers readers,
+// puts and the buffer pool all working at once.
+func TestCopyFileToAzureAndBack(t *testing.T) {
+	fake := newFakeBlobs()
+	defer fake.Close()
+	azure := fake.provider()
+
+	dir, err := ioutil.TempDir("", "stor-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 7*BlockSize()+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	source := filepath.Join(dir, "source")
+	err = ioutil.WriteFile(source, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copyThrough(t, &FileProvider{}, source, azure, "/copies/source")
+	uploaded, ok := fake.blob("copies/source")
+	if !ok || !bytes.Equal(uploaded, data) {
+		t.Fatalf("uploaded %d bytes, want the %d bytes of the source", len(uploaded), len(data))
+	}
+
+	target := filepath.Join(dir, "target")
+	copyThrough(t, azure, "/copies/source", &FileProvider{}, target)
+	downloaded, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, data) {
+		t.Fatalf("downloaded %d bytes that differ from the %d uploaded", len(downloaded), len(data))
+	}
+}
+
+// Blobs within putBlobThreshold go up in one Put Blob. The threshold is capped
+// at blockSize so anything larger is sent in blocks.
+func TestCopyUnderPutBlobThreshold(t *testing.T) {
+	viper.Set("putBlobThreshold", 4*BlockSize())
+	defer viper.Set("putBlobThreshold", BlockSize())
+
+	fake := newFakeBlobs()
+	defer fake.Close()
+	azure := fake.provider()
+
+	dir, err := ioutil.TempDir("", "stor-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		length   int
+		putBlobs int
+	}{
+		{0, 1},
+		{100, 1},
+		{BlockSize(), 1},
+		{BlockSize() + 1, 0},
+		{3*BlockSize() + 100, 0},
+	}
+	for _, test := range tests {
+		data := make([]byte, test.length)
+		rand.New(rand.NewSource(int64(test.length))).Read(data)
+		source := filepath.Join(dir, "source")
+		err = ioutil.WriteFile(source, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		putBlobs := fake.putBlobs
+		copyThrough(t, &FileProvider{}, source, azure, "/copies/source")
+		uploaded, ok := fake.blob("copies/source")
+		if !ok || !bytes.Equal(uploaded, data) {
+			t.Fatalf("uploaded %d bytes, want the %d bytes of the source", len(uploaded), len(data))
+		}
+		if fake.putBlobs-putBlobs != test.putBlobs {
+			t.Errorf("%d bytes took %d Put Blobs, want %d", test.length, fake.putBlobs-putBlobs, test.putBlobs)
+		}
+
+		target := filepath.Join(dir, "target")
+		copyThrough(t, azure, "/copies/source", &FileProvider{}, target)
+		downloaded, err := ioutil.ReadFile(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(downloaded, data) {
+			t.Fatalf("downloaded %d bytes that differ from the %d uploaded", len(downloaded), len(data))
+		}
+	}
+}
+
+// A stream that doesn't match the planned length is never sent as the blob.
+func TestPutBlobLengthMismatch(t *testing.T) {
+	fake := newFakeBlobs()
+	defer fake.Close()
+	azure := fake.provider()
+
+	tests := []struct {
+		blocks []int
+		length int64
+	}{
+		{[]int{100}, 200},
+		{[]int{200}, 100},
+		{[]int{50, 50}, 100},
+	}
+	for _, test := range tests {
+		stream := make(chan *Block, len(test.blocks))
+		for i, size := range test.blocks {
+			buf := GetBuffer(size)
+			stream <- &Block{Bytes: buf, Ordinal: i}
+		}
+		close(stream)
+		err := azure.Create("/short", stream, len(test.blocks), test.length, InitTokenBucket(), false)
+		if err == nil {
+			t.Errorf("blocks %v of a %d byte blob were put", test.blocks, test.length)
+		}
+	}
+	if _, ok := fake.blob("short"); ok {
+		t.Error("a blob was put")
+	}
+}
+
+// buffersReturned fails the test unless every buffer is back in the pool once
+// the readers and drains of an abandoned stream have had time to finish.
+func buffersReturned(t *testing.T) {
+	t.Helper()
+	bp := getBufferPool()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		bp.mu.Lock()
+		inUse := bp.inUse
+		bp.mu.Unlock()
+		if inUse == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d bytes of buffers still in use", inUse)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// queued waits until count GetBuffers are waiting their turn.
+func queued(bp *bufferPool, count uint64) {
+	for {
+		bp.mu.Lock()
+		waiting := bp.next - bp.serving
+		bp.mu.Unlock()
+		if waiting == count {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// A buffer larger than max_memory needs everything else put back. Small ones
+// asked for after it must wait behind it instead of keeping it out for good.
+func TestLargeBufferIsNotStarved(t *testing.T) {
+	bp := getBufferPool()
+	held := GetBuffer(BlockSize())
+
+	large := make(chan []byte)
+	go func() { large <- GetBuffer(int(bp.max) + 1) }()
+	queued(bp, 1)
+	small := make(chan []byte)
+	go func() { small <- GetBuffer(BlockSize()) }()
+	queued(bp, 2)
+
+	PutBuffer(held)
+	select {
+	case buf := <-large:
+		PutBuffer(buf)
+	case buf := <-small:
+		PutBuffer(buf)
+		t.Fatal("a small buffer went ahead of the large one waiting for it")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the large buffer is still waiting")
+	}
+	PutBuffer(<-small)
+}
@@ -0,0 +1,129 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Kind classifies provider failures so callers can react to them without
+// parsing messages.
+type Kind int
+
+const (
+	Other Kind = iota
+	NotFound
+	AuthFailed
+	Throttled
+	Conflict
+	InvalidConfig
+)
+
+func (k Kind) String() string {
+	switch k {
+	case NotFound:
+		return "not found"
+	case AuthFailed:
+		return "authentication failed"
+	case Throttled:
+		return "throttled"
+	case Conflict:
+		return "conflict"
+	case InvalidConfig:
+		return "invalid config"
+	}
+	return "error"
+}
+
+// Error is the error returned by providers. For Azure the Code and Message are
+// taken from the <Error> body (or x-ms-error-code header) of a non 2xx response.
+type Error struct {
+	Kind       Kind
+	StatusCode int
+	Code       string
+	Message    string
+	Err        error
+}
+
+func (e *Error) Error() string {
+	var builder strings.Builder
+	builder.WriteString(e.Kind.String())
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&builder, " (status %d)", e.StatusCode)
+	}
+	if e.Code != "" {
+		fmt.Fprintf(&builder, ": %s", e.Code)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&builder, ": %s", e.Message)
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&builder, ": %v", e.Err)
+	}
+	return builder.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the Kind of the first *Error in err's chain or Other.
+func KindOf(err error) Kind {
+	var providerErr *Error
+	if errors.As(err, &providerErr) {
+		return providerErr.Kind
+	}
+	return Other
+}
+
+func configError(format string, args ...interface{}) error {
+	return &Error{Kind: InvalidConfig, Message: fmt.Sprintf(format, args...)}
+}
+
+// fileError gives filesystem errors a Kind where one applies.
+func fileError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if os.IsNotExist(err) {
+		return &Error{Kind: NotFound, Err: err}
+	}
+	return err
+}
+
+// statusKind maps an http status and storage error code to a Kind.
+func statusKind(statusCode int, code string) Kind {
+	switch code {
+	case "AuthenticationFailed", "AuthorizationFailure", "InsufficientAccountPermissions":
+		return AuthFailed
+	case "ServerBusy", "OperationTimedOut":
+		return Throttled
+	}
+
+	switch statusCode {
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return AuthFailed
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return Throttled
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return Conflict
+	}
+	return Other
+}
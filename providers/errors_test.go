@@ -0,0 +1,47 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStatusKind(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		code       string
+		want       Kind
+	}{
+		{http.StatusNotFound, "BlobNotFound", NotFound},
+		{http.StatusNotFound, "ContainerNotFound", NotFound},
+		{http.StatusNotFound, "", NotFound},
+		{http.StatusForbidden, "AuthenticationFailed", AuthFailed},
+		{http.StatusForbidden, "AuthorizationPermissionMismatch", AuthFailed},
+		{http.StatusUnauthorized, "", AuthFailed},
+		{http.StatusBadRequest, "InsufficientAccountPermissions", AuthFailed},
+		{http.StatusServiceUnavailable, "ServerBusy", Throttled},
+		{http.StatusInternalServerError, "OperationTimedOut", Throttled},
+		{http.StatusTooManyRequests, "", Throttled},
+		{http.StatusConflict, "BlobAlreadyExists", Conflict},
+		{http.StatusPreconditionFailed, "ConditionNotMet", Conflict},
+		{http.StatusBadRequest, "InvalidBlockList", Other},
+		{http.StatusInternalServerError, "InternalError", Other},
+	}
+	for _, test := range tests {
+		if got := statusKind(test.statusCode, test.code); got != test.want {
+			t.Errorf("statusKind(%d, %q) = %v, want %v", test.statusCode, test.code, got, test.want)
+		}
+	}
+}
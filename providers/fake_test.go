@@ -0,0 +1,215 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fakeBlobs is an in memory stand-in for the blob service with just enough of
+// Put Block, Put Block List, Get Block List, Put Blob, Get Blob, Get Blob Properties, List Blobs and Delete Blob for the
+// providers to copy through it. Requests are authorized with a SAS, which isn't checked.
+type fakeBlobs struct {
+	*httptest.Server
+	mu     sync.Mutex
+	blobs  map[string][]byte
+	blocks map[string]map[string][]byte
+	// putBlobs counts the blobs sent with a single Put Blob and putBlocks the
+	// blocks put that were accepted.
+	putBlobs  int
+	putBlocks int
+	// blockLimit, when above 0, is how many Put Blocks are accepted before the
+	// rest fail with 403 AuthorizationFailure.
+	blockLimit int
+	// blockLists counts the Put Block Lists that committed a blob and listPages
+	// the pages of List Blobs results served.
+	blockLists int
+	listPages  int
+	// headers are sent with a blob's Get Blob Properties.
+	headers map[string]http.Header
+}
+
+func newFakeBlobs() *fakeBlobs {
+	fake := &fakeBlobs{
+		blobs:   make(map[string][]byte),
+		blocks:  make(map[string]map[string][]byte),
+		headers: make(map[string]http.Header),
+	}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serve))
+	return fake
+}
+
+// provider is an AzureProvider for the container cont of the fake.
+func (fake *fakeBlobs) provider() *AzureProvider {
+	return &AzureProvider{
+		AccountName:   "acct",
+		ContainerName: "cont",
+		SAS:           "sv=2017-11-09&sig=test",
+		Endpoint:      fake.URL + "/acct",
+	}
+}
+
+// blob is the committed content of name in cont.
+func (fake *fakeBlobs) blob(name string) ([]byte, bool) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	data, ok := fake.blobs["/acct/cont/"+strings.TrimPrefix(name, "/")]
+	return data, ok
+}
+
+func (fake *fakeBlobs) serve(w http.ResponseWriter, r *http.Request) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	query := r.URL.Query()
+	name := r.URL.Path
+
+	switch {
+	case r.Method == "PUT" && query.Get("comp") == "block":
+		if fake.blockLimit > 0 && fake.putBlocks >= fake.blockLimit {
+			w.Header().Set("x-ms-error-code", "AuthorizationFailure")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fake.putBlocks++
+		if fake.blocks[name] == nil {
+			fake.blocks[name] = make(map[string][]byte)
+		}
+		fake.blocks[name][query.Get("blockid")] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "PUT" && query.Get("comp") == "blocklist":
+		var list struct {
+			Uncommitted []string `xml:"Uncommitted"`
+		}
+		xml.Unmarshal(body, &list)
+		var data []byte
+		for _, id := range list.Uncommitted {
+			block, ok := fake.blocks[name][id]
+			if !ok {
+				w.Header().Set("x-ms-error-code", "InvalidBlockList")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data = append(data, block...)
+		}
+		fake.blobs[name] = data
+		delete(fake.blocks, name)
+		fake.blockLists++
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "PUT":
+		fake.blobs[name] = body
+		fake.putBlobs++
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "HEAD":
+		data, ok := fake.blobs[name]
+		if !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for key, values := range fake.headers[name] {
+			w.Header()[key] = values
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		w.WriteHeader(http.StatusOK)
+	case r.Method == "GET" && query.Get("comp") == "list":
+		fake.list(w, name, query)
+	case r.Method == "GET" && query.Get("comp") == "blocklist":
+		blocks, ok := fake.blocks[name]
+		if !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		list := BlockList{}
+		for id, block := range blocks {
+			list.UncommittedBlocks = append(list.UncommittedBlocks, BlockEntry{Name: id, Size: len(block)})
+		}
+		body, _ := xml.Marshal(list)
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	case r.Method == "GET":
+		data, ok := fake.blobs[name]
+		if !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var start, end int64 = 0, int64(len(data)) - 1
+		if byteRange := r.Header.Get("x-ms-range"); byteRange != "" {
+			fmt.Sscanf(byteRange, "bytes=%d-%d", &start, &end)
+			if end >= int64(len(data)) {
+				end = int64(len(data)) - 1
+			}
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(end+1-start, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[start : end+1])
+	case r.Method == "DELETE":
+		delete(fake.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// list answers List Blobs for the container at containerPath a page of
+// maxresults names at a time, with the name that starts the next page as its
+// NextMarker.
+func (fake *fakeBlobs) list(w http.ResponseWriter, containerPath string, query url.Values) {
+	fake.listPages++
+	root := containerPath + "/"
+	var names []string
+	for key := range fake.blobs {
+		name := strings.TrimPrefix(key, root)
+		if strings.HasPrefix(key, root) && strings.HasPrefix(name, query.Get("prefix")) && name >= query.Get("marker") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	maxResults, _ := strconv.Atoi(query.Get("maxresults"))
+	if maxResults <= 0 {
+		maxResults = 5000
+	}
+	results := EnumerationResults{Prefix: query.Get("prefix"), Marker: query.Get("marker"), MaxResults: maxResults}
+	if len(names) > maxResults {
+		results.NextMarker = names[maxResults]
+		names = names[:maxResults]
+	}
+	for _, name := range names {
+		data := fake.blobs[root+name]
+		results.Blobs = append(results.Blobs, Blob{
+			Name:          name,
+			LastModified:  "Mon, 02 Jan 2006 15:04:05 GMT",
+			ContentLength: int64(len(data)),
+			ContentMD5:    contentMD5(data),
+			BlobType:      "BlockBlob",
+		})
+	}
+	body, _ := xml.Marshal(results)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
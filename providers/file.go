@@ -14,8 +14,12 @@
 package providers
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 
 	jww "github.com/spf13/jwalterweatherman"
 )
@@ -27,52 +31,180 @@ func (fp *FileProvider) ProviderName() string {
 	return "file"
 }
 
-func (fp *FileProvider) Create(name string, stream <-chan *Block, blockCount int, tokenBucket chan int) error {
+// Create writes the stream to a temp file next to name and renames it into
+// place once every block is synced so a failed copy never leaves a partial
+// file under the real name.
+func (fp *FileProvider) Create(name string, stream <-chan *Block, blockCount int, length int64, tokenBucket chan int, resume bool) error {
+	jww.INFO.Printf("Create local file: %s with %d blocks", name, blockCount)
+
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		go drain(stream)
+		return err
+	}
+
+	file, err := ioutil.TempFile(dir, "."+base+".stor-")
+	if err != nil {
+		go drain(stream)
+		return err
+	}
+	jww.INFO.Println("Writing to temp file:", file.Name())
+
+	fail := func(err error) error {
+		go drain(stream)
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	//Blocks arrive in any order so each one is written at its own offset
+	blockSize := int64(BlockSize())
+	for block := range stream {
+		if block.Err != nil {
+			return fail(block.Err)
+		}
+		_, err := file.WriteAt(block.Bytes, int64(block.Ordinal)*blockSize)
+		PutBuffer(block.Bytes)
+		if err != nil {
+			return fail(err)
+		}
+		jww.INFO.Printf("Wrote Block.Id[%d] with length %d bytes", block.Ordinal, len(block.Bytes))
+	}
+
+	err = file.Chmod(0644)
+	if err != nil {
+		return fail(err)
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return fail(err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return fail(err)
+	}
+
+	err = os.Rename(file.Name(), name)
+	if err != nil {
+		return fail(err)
+	}
+
 	return nil
 }
 
 func (fp *FileProvider) Open(name string, stream chan<- *Block, tokenBucket chan int, blockCount int, blockSize int) error {
-	jww.INFO.Printf("Open local file: %s with %d blocks of %d size", name, blockCount, blockSize)
+	return fp.OpenRange(name, stream, tokenBucket, 0, int64(blockCount)*int64(blockSize), blockSize)
+}
+
+// OpenRange reads length bytes of the file from start with up to
+// reader_concurrency blocks read at once at their own offsets. Ordinals count
+// from start. The range may run past the end of the file into the last block.
+func (fp *FileProvider) OpenRange(name string, stream chan<- *Block, tokenBucket chan int, start int64, length int64, blockSize int) error {
+	blockCount := int((length + int64(blockSize) - 1) / int64(blockSize))
+	jww.INFO.Printf("Open local file: %s from %d with %d blocks of %d size", name, start, blockCount, blockSize)
+
+	file, err := os.Open(name)
+	if err != nil {
+		return fileError(err)
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fileError(err)
+	}
+	end := start + length
+	if end > fileInfo.Size() {
+		end = fileInfo.Size()
+	}
 
 	go func() {
-		file, err := os.Open(name)
 		defer file.Close()
-		if err != nil {
-			jww.ERROR.Println("Bad local file open:", name)
-			jww.ERROR.Println(err)
-			os.Exit(1)
+		defer close(stream)
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var readErr error
+		failed := func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return readErr != nil
 		}
 
-		readBuffer := make([]byte, blockSize)
+		readers := make(chan struct{}, ReaderConcurrency())
+		for i := 0; i < blockCount && !failed(); i++ {
+			//GetBuffer waits while max_memory is taken by blocks not yet written.
+			//Buffers are taken in order so the blocks the writer waits on come first.
+			readBuffer := GetBuffer(blockSize)
+			readers <- struct{}{}
+			wg.Add(1)
+
+			go func(ordinal int, readBuffer []byte) {
+				defer func() { <-readers }()
+				defer wg.Done()
 
-		for i := 0; i < blockCount; i++ {
-			n, err := file.Read(readBuffer)
-			if err != nil {
-				jww.ERROR.Println("Bad block read from file: ", name)
-				jww.ERROR.Println(err)
-				os.Exit(1)
-			}
+				block, err := readBlock(file, start, end, ordinal, readBuffer)
+				if err != nil {
+					PutBuffer(readBuffer)
+					mu.Lock()
+					defer mu.Unlock()
+					if readErr == nil {
+						readErr = fmt.Errorf("read block %d of %s: %w", ordinal, name, err)
+					}
+					return
+				}
 
-			block := &Block{
-				append([]byte{}, readBuffer[:n]...),
-				i,
-			}
+				jww.INFO.Printf("Read to Block.Id[%d] with length %d bytes", block.Ordinal, len(block.Bytes))
+				stream <- block
+			}(i, readBuffer)
+		}
 
-			jww.INFO.Printf("Read to Block.Id[%d] with length %d bytes", block.Ordinal, len(block.Bytes))
-			stream <- block
+		wg.Wait()
+		if readErr != nil {
+			stream <- &Block{Err: readErr}
 		}
-		close(stream)
 	}()
 
 	return nil
 }
 
-func (fp *FileProvider) Stat(name string) *BlobInfo {
+// readBlock reads block ordinal of the range [start, end) at its own offset.
+// Only the last block of the range may be short, so a file that shrank after
+// it was planned leaves a block with nothing to read, which is an error.
+func readBlock(file *os.File, start int64, end int64, ordinal int, readBuffer []byte) (*Block, error) {
+	offset := start + int64(ordinal)*int64(len(readBuffer))
+	want := end - offset
+	if want > int64(len(readBuffer)) {
+		want = int64(len(readBuffer))
+	}
+	if want <= 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	n, err := file.ReadAt(readBuffer[:want], offset)
+	if err == io.EOF && int64(n) == want {
+		err = nil
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Block{Bytes: readBuffer[:n], Ordinal: ordinal}, nil
+}
+
+func (fp *FileProvider) Stat(name string) (*BlobInfo, error) {
 	fileInfo, err := os.Lstat(name)
 	if err != nil {
-		jww.ERROR.Println("Bad filename Stat:", name)
-		jww.ERROR.Println(err)
-		os.Exit(1)
+		return nil, fileError(err)
 	}
 	var blobInfo *BlobInfo = &BlobInfo{}
 	blobInfo.Name = fileInfo.Name()
@@ -81,24 +213,20 @@ func (fp *FileProvider) Stat(name string) *BlobInfo {
 	blobInfo.LastModified = fileInfo.ModTime()
 	blobInfo.BlobType = "FileSystem"
 	blobInfo.IsDir = fileInfo.IsDir()
-	return blobInfo
+	return blobInfo, nil
 }
 
-func (fp *FileProvider) Glob(pattern string) []*BlobInfo {
+func (fp *FileProvider) Glob(pattern string) ([]*BlobInfo, error) {
 	paths, err := filepath.Glob(pattern)
 	if err != nil {
-		jww.ERROR.Println("Bad filepath Glob:", pattern)
-		jww.ERROR.Println(err)
-		os.Exit(1)
+		return nil, err
 	}
 
 	matches := make([]*BlobInfo, len(paths))
 	for i, path := range paths {
 		fileInfo, err := os.Stat(path)
 		if err != nil {
-			jww.ERROR.Println("Bad filepath Stat:", path)
-			jww.ERROR.Println(err)
-			os.Exit(1)
+			return nil, fileError(err)
 		}
 		var blobInfo *BlobInfo = &BlobInfo{}
 		blobInfo.Name = fileInfo.Name()
@@ -106,7 +234,29 @@ func (fp *FileProvider) Glob(pattern string) []*BlobInfo {
 		blobInfo.LastModified = fileInfo.ModTime()
 		blobInfo.BlobType = "FileSystem"
 		blobInfo.PathName = path
+		blobInfo.IsDir = fileInfo.IsDir()
 		matches[i] = blobInfo
 	}
-	return matches
+	return matches, nil
+}
+
+// Walk calls walkFn for each Glob match. The filesystem has no paging so this
+// is only here to satisfy Provider.
+func (fp *FileProvider) Walk(pattern string, walkFn WalkFunc) error {
+	matches, err := fp.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	for _, blobInfo := range matches {
+		err := walkFn(blobInfo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the file or empty directory.
+func (fp *FileProvider) Delete(name string) error {
+	return fileError(os.Remove(name))
 }
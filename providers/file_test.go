@@ -0,0 +1,70 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReadBlock(t *testing.T) {
+	file, err := ioutil.TempFile("", "stor-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	data := []byte("0123456789abcdefghij") // 20 bytes
+	_, err = file.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		start, end int64
+		ordinal    int
+		want       string
+		err        error
+	}{
+		{0, 20, 0, "01234567", nil},
+		{0, 20, 1, "89abcdef", nil},
+		// The last block of the range is short
+		{0, 20, 2, "ghij", nil},
+		{4, 12, 0, "456789ab", nil},
+		{4, 14, 1, "cd", nil},
+		{0, 16, 1, "89abcdef", nil},
+		// Planned for a file longer than it now is
+		{0, 24, 2, "", io.ErrUnexpectedEOF},
+		{0, 32, 3, "", io.ErrUnexpectedEOF},
+		// Nothing left of the range for this ordinal
+		{0, 16, 2, "", io.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		block, err := readBlock(file, test.start, test.end, test.ordinal, make([]byte, 8))
+		if err != test.err {
+			t.Errorf("readBlock(%d, %d, %d) error %v, want %v", test.start, test.end, test.ordinal, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !bytes.Equal(block.Bytes, []byte(test.want)) || block.Ordinal != test.ordinal {
+			t.Errorf("readBlock(%d, %d, %d) = block %d %q, want %q", test.start, test.end, test.ordinal,
+				block.Ordinal, block.Bytes, test.want)
+		}
+	}
+}
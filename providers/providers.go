@@ -15,8 +15,8 @@ package providers
 
 import (
 	"fmt"
+	"hash"
 	"math"
-	"os"
 	"regexp"
 	"runtime"
 	"strings"
@@ -33,79 +33,116 @@ const (
 	MAX_BLOCKS     = 50000             //Azure Max block count
 	MIN_BLOCK_SIZE = 1024 * 5
 	MAX_BLOCK_SIZE = 1024 * 1024 * 100 //Azure max size
+	UNKNOWN        = -1                //Length and block count of a stream that is only known at EOF
 )
 
 type BlobInfo struct {
-	Name         string
-	PathName     string
-	CreatedAt    time.Time
-	LastModified time.Time
-	Length       int64
-	Etag         string
-	Encoding     string
-	Type         string
-	MD5          string
-	BlobType     string
-	IsDir        bool
+	Name         string            `json:"name"`
+	PathName     string            `json:"pathName"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	LastModified time.Time         `json:"lastModified"`
+	Length       int64             `json:"length"`
+	Etag         string            `json:"etag,omitempty"`
+	Encoding     string            `json:"contentEncoding,omitempty"`
+	Type         string            `json:"contentType,omitempty"`
+	MD5          string            `json:"contentMD5,omitempty"`
+	BlobType     string            `json:"blobType,omitempty"`
+	AccessTier   string            `json:"accessTier,omitempty"`
+	LeaseState   string            `json:"leaseState,omitempty"`
+	LeaseStatus  string            `json:"leaseStatus,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	IsDir        bool              `json:"isDir"`
 }
 
+// Block is one chunk of a stream. A producer that fails part way sends a
+// Block with Err set as its last Block before closing the stream. Bytes come
+// from GetBuffer and the consumer hands them back with PutBuffer when done.
 type Block struct {
 	Bytes   []byte
 	Ordinal int
+	Err     error
 }
 
+// WalkFunc is called by Walk for each match. A non nil error stops the walk
+// and is returned by Walk.
+type WalkFunc func(info *BlobInfo) error
+
+// Provider is a source or target of blocks. A blockCount and length of UNKNOWN
+// mean the stream's blocks end when it is closed. OpenRange streams length
+// bytes from start, e.g. for cat --range. Create with resume may keep what an
+// interrupted Create of the same name left behind.
 type Provider interface {
-	Create(name string, stream <-chan *Block, blockCount int, tokenBucket chan int) error
+	Create(name string, stream <-chan *Block, blockCount int, length int64, tokenBucket chan int, resume bool) error
 	Open(name string, stream chan<- *Block, tokenBucket chan int, blockCount int, blockSize int) error
-	Glob(pattern string) []*BlobInfo
-	Stat(name string) *BlobInfo
+	OpenRange(name string, stream chan<- *Block, tokenBucket chan int, start int64, length int64, blockSize int) error
+	Glob(pattern string) ([]*BlobInfo, error)
+	Walk(pattern string, walkFn WalkFunc) error
+	Stat(name string) (*BlobInfo, error)
+	Delete(name string) error
 	ProviderName() string
 }
 
-func Create(alias string) Provider {
+func Create(alias string) (Provider, error) {
+	if alias == STDIO {
+		return newStreamProvider(), nil
+	}
 	providerName := viper.GetString(fmt.Sprintf("aliases.%s.provider", alias))
 
 	switch providerName {
 	case "azure":
-		sub := viper.Sub(fmt.Sprintf("aliases.%s", alias))
-		return &AzureProvider{
-			sub.GetString("accountName"),
-			sub.GetString("name"),
-			sub.GetString("key"),
-		}
+		return newAzureProvider(alias, viper.Sub(fmt.Sprintf("aliases.%s", alias)))
 	case "file":
-		return &FileProvider{}
+		return &FileProvider{}, nil
 	}
-	jww.ERROR.Println("No provider found for alias: ", alias)
-	jww.ERROR.Println("Check config or alias matching?")
-	os.Exit(1)
-	//Won't get here
-	return nil
+	return nil, configError("no provider found for alias: %s. Check config or alias matching?", alias)
 }
 
 var aliasMatcher *regexp.Regexp = regexp.MustCompile("//([A-Za-z]+)(/.*)")
 
-func Parse(aliasedPath string) (alias string, pathName string) {
+func Parse(aliasedPath string) (alias string, pathName string, err error) {
+	if aliasedPath == STDIO {
+		return STDIO, STDIO, nil
+	}
 	if !isAlias(aliasedPath) {
-		return "file", aliasedPath
+		return "file", aliasedPath, nil
 	}
 
 	matches := aliasMatcher.FindStringSubmatch(aliasedPath)
 	jww.TRACE.Println("//<alias>/<blobName> matches ->", matches)
 	if len(matches) != 3 {
-		jww.ERROR.Println("Bad alias. Won't parse.", aliasedPath)
-		jww.ERROR.Println("Matches:", matches)
-		os.Exit(1)
+		return "", "", configError("bad alias. Won't parse: %s", aliasedPath)
 	}
 
 	alias = matches[1]
 	pathName = matches[2]
-	return alias, pathName
+	return alias, pathName, nil
 }
 
+//Defaults are set once here since viper isn't safe to write while blocks are in flight
+func init() {
+	viper.SetDefault("max_concurrency", runtime.NumCPU()*10)
+}
+
+// Concurrency is the max_concurrency setting, 10 per CPU by default.
+func Concurrency() int {
+	return viper.GetInt("max_concurrency")
+}
+
+// ReaderConcurrency is the reader_concurrency setting, the number of blocks of
+// one local file read at once. 4 by default.
+func ReaderConcurrency() int {
+	readers := viper.GetInt("reader_concurrency")
+	if readers <= 0 {
+		readers = 4
+	}
+	return readers
+}
+
+// InitTokenBucket makes the bucket of max_concurrency tokens a command's requests
+// share. It also sets up the buffer pool while nothing else is running.
 func InitTokenBucket() chan int {
-	viper.SetDefault("max_concurrency", runtime.NumCPU() * 10)
-	bucketSize := viper.GetInt("max_concurrency")
+	getBufferPool()
+	bucketSize := Concurrency()
 	jww.TRACE.Println("Token Bucket Size: ", bucketSize)
 	tokenBucket := make(chan int, bucketSize)
 	for i := 0; i < bucketSize; i++ {
@@ -114,7 +151,8 @@ func InitTokenBucket() chan int {
 	return tokenBucket
 }
 
-func CalculateBlocks(info *BlobInfo) (int, int) {
+// BlockSize is the configured blockSize clamped to the allowed range.
+func BlockSize() int {
 	blockSize := viper.GetInt("blockSize")
 
 	if blockSize < MIN_BLOCK_SIZE {
@@ -126,13 +164,86 @@ func CalculateBlocks(info *BlobInfo) (int, int) {
 		jww.INFO.Println("Set Blocksize to maximum allowed:", MAX_BLOCK_SIZE)
 		blockSize = MAX_BLOCK_SIZE
 	}
+	return blockSize
+}
+
+// PutBlobThreshold is the largest upload sent with a single Put Blob instead of
+// Put Block and Put Block List. It defaults to blockSize and 0 turns it off. It
+// is never more than blockSize so the one block sent is a buffer from the pool.
+func PutBlobThreshold() int {
+	blockSize := BlockSize()
+	if !viper.IsSet("putBlobThreshold") {
+		return blockSize
+	}
+
+	threshold := viper.GetInt("putBlobThreshold")
+	if threshold > blockSize {
+		jww.INFO.Println("Set putBlobThreshold to blockSize:", blockSize)
+		threshold = blockSize
+	}
+	return threshold
+}
+
+// CalculateBlocks splits info into blocks of blockSize.
+func CalculateBlocks(info *BlobInfo) (int, int, error) {
+	blockSize := BlockSize()
+
+	if info.Length == UNKNOWN {
+		return UNKNOWN, blockSize, nil
+	}
 
 	blockCount := int(math.Ceil(float64(info.Length) / float64(blockSize)))
 	if blockCount > MAX_BLOCKS {
-		jww.ERROR.Printf("Too many blocks. Max is %d. %d requested. Maybe adjust blockSize?", MAX_BLOCKS, blockCount)
-		os.Exit(1)
+		return 0, 0, configError("too many blocks. Max is %d. %d requested. Maybe adjust blockSize?", MAX_BLOCKS, blockCount)
+	}
+	return blockCount, blockSize, nil
+}
+
+// orderedHash feeds blocks to a hash in ordinal order whatever order they
+// arrive in. Blocks ahead of the next ordinal are held until the gap is filled.
+type orderedHash struct {
+	hash.Hash
+	next    int
+	pending map[int]*Block
+}
+
+func newOrderedHash(h hash.Hash) *orderedHash {
+	return &orderedHash{Hash: h, pending: make(map[int]*Block)}
+}
+
+// add hashes block if it is next along with any held blocks it was holding
+// up and returns the blocks hashed so far, which it no longer needs.
+func (o *orderedHash) add(block *Block) []*Block {
+	o.pending[block.Ordinal] = block
+	var hashed []*Block
+	for {
+		next, ok := o.pending[o.next]
+		if !ok {
+			return hashed
+		}
+		o.Write(next.Bytes)
+		hashed = append(hashed, next)
+		delete(o.pending, o.next)
+		o.next++
+	}
+}
+
+// held returns the blocks still waiting on an earlier one and forgets them.
+func (o *orderedHash) held() []*Block {
+	var blocks []*Block
+	for ordinal, block := range o.pending {
+		blocks = append(blocks, block)
+		delete(o.pending, ordinal)
+	}
+	return blocks
+}
+
+// drain discards the rest of a stream so its producer can finish after the
+// consumer has given up on it. The blocks' buffers go back to the pool.
+func drain(stream <-chan *Block) {
+	for block := range stream {
+		PutBuffer(block.Bytes)
 	}
-	return blockCount, blockSize
 }
 
 func isAlias(arg string) bool {
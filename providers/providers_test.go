@@ -0,0 +1,71 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"testing"
+)
+
+func TestOrderedHash(t *testing.T) {
+	parts := [][]byte{[]byte("alpha "), []byte("beta "), []byte("gamma "), []byte("delta")}
+	want := md5.Sum(bytes.Join(parts, nil))
+
+	tests := []struct {
+		order []int
+		// hashed is how many blocks each add hands back
+		hashed []int
+	}{
+		{[]int{0, 1, 2, 3}, []int{1, 1, 1, 1}},
+		{[]int{3, 2, 1, 0}, []int{0, 0, 0, 4}},
+		{[]int{1, 0, 3, 2}, []int{0, 2, 0, 2}},
+		{[]int{2, 0, 3, 1}, []int{0, 1, 0, 3}},
+	}
+	for _, test := range tests {
+		o := newOrderedHash(md5.New())
+		for i, ordinal := range test.order {
+			hashed := o.add(&Block{Bytes: parts[ordinal], Ordinal: ordinal})
+			if len(hashed) != test.hashed[i] {
+				t.Errorf("order %v: add of block %d hashed %d blocks, want %d", test.order, ordinal, len(hashed), test.hashed[i])
+			}
+		}
+		if held := o.held(); len(held) != 0 {
+			t.Errorf("order %v: %d blocks still held", test.order, len(held))
+		}
+		if got := o.Sum(nil); !bytes.Equal(got, want[:]) {
+			t.Errorf("order %v: hash %x, want %x", test.order, got, want)
+		}
+	}
+}
+
+// Blocks after a gap are held until it is filled and can be taken back with held.
+func TestOrderedHashHeld(t *testing.T) {
+	o := newOrderedHash(md5.New())
+	o.add(&Block{Bytes: []byte("a"), Ordinal: 0})
+	o.add(&Block{Bytes: []byte("c"), Ordinal: 2})
+	o.add(&Block{Bytes: []byte("d"), Ordinal: 3})
+
+	held := o.held()
+	if len(held) != 2 {
+		t.Fatalf("%d blocks held, want 2", len(held))
+	}
+	if len(o.held()) != 0 {
+		t.Error("held blocks are still pending after held")
+	}
+	want := md5.Sum([]byte("a"))
+	if got := o.Sum(nil); !bytes.Equal(got, want[:]) {
+		t.Errorf("hash %x, want only the first block %x", got, want)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"io"
+	"os"
+
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+// STDIO is the source or target name for stdin and stdout, as in 'stor cp - //alias/blob'.
+const STDIO = "-"
+
+// StreamProvider reads blocks from stdin and writes them to stdout. stdin has no
+// length up front so it is opened with UNKNOWN blocks and is split into blocks
+// as it is read until EOF.
+type StreamProvider struct {
+	In  io.Reader
+	Out io.Writer
+}
+
+func newStreamProvider() *StreamProvider {
+	return &StreamProvider{In: os.Stdin, Out: os.Stdout}
+}
+
+func (sp *StreamProvider) ProviderName() string {
+	return "stream"
+}
+
+// Create writes the stream to stdout in ordinal order. Blocks that arrive ahead
+// of the next one are held until it comes.
+func (sp *StreamProvider) Create(name string, stream <-chan *Block, blockCount int, length int64, tokenBucket chan int, resume bool) error {
+	jww.INFO.Printf("Create stdout with %d blocks", blockCount)
+
+	next := 0
+	pending := make(map[int]*Block)
+	fail := func(err error) error {
+		for _, block := range pending {
+			PutBuffer(block.Bytes)
+		}
+		go drain(stream)
+		return err
+	}
+
+	for block := range stream {
+		if block.Err != nil {
+			return fail(block.Err)
+		}
+		pending[block.Ordinal] = block
+		for {
+			block, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			_, err := sp.Out.Write(block.Bytes)
+			PutBuffer(block.Bytes)
+			if err != nil {
+				return fail(err)
+			}
+			jww.INFO.Printf("Wrote Block.Id[%d] with length %d bytes", block.Ordinal, len(block.Bytes))
+		}
+	}
+	return nil
+}
+
+// Open reads stdin into blocks of blockSize until EOF. The last block is
+// usually short. A stream longer than MAX_BLOCKS blocks is an error.
+func (sp *StreamProvider) Open(name string, stream chan<- *Block, tokenBucket chan int, blockCount int, blockSize int) error {
+	jww.INFO.Printf("Open stdin with blocks of %d size", blockSize)
+
+	go func() {
+		defer close(stream)
+
+		for i := 0; ; i++ {
+			readBuffer := GetBuffer(blockSize)
+			n, err := io.ReadFull(sp.In, readBuffer)
+			if err == io.EOF {
+				PutBuffer(readBuffer)
+				return
+			}
+			if err != nil && err != io.ErrUnexpectedEOF {
+				PutBuffer(readBuffer)
+				stream <- &Block{Err: err}
+				return
+			}
+			if i >= MAX_BLOCKS {
+				PutBuffer(readBuffer)
+				stream <- &Block{Err: configError("stdin is more than %d blocks. Maybe adjust blockSize?", MAX_BLOCKS)}
+				return
+			}
+
+			jww.INFO.Printf("Read to Block.Id[%d] with length %d bytes", i, n)
+			stream <- &Block{Bytes: readBuffer[:n], Ordinal: i}
+			if err == io.ErrUnexpectedEOF {
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (sp *StreamProvider) OpenRange(name string, stream chan<- *Block, tokenBucket chan int, start int64, length int64, blockSize int) error {
+	return configError("stdin can only be read from the start")
+}
+
+// Stat describes stdin, whose length is UNKNOWN until it has been read.
+func (sp *StreamProvider) Stat(name string) (*BlobInfo, error) {
+	return &BlobInfo{Name: STDIO, PathName: STDIO, Length: UNKNOWN}, nil
+}
+
+func (sp *StreamProvider) Glob(pattern string) ([]*BlobInfo, error) {
+	blobInfo, err := sp.Stat(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return []*BlobInfo{blobInfo}, nil
+}
+
+func (sp *StreamProvider) Walk(pattern string, walkFn WalkFunc) error {
+	return configError("stdin and stdout can't be listed")
+}
+
+func (sp *StreamProvider) Delete(name string) error {
+	return configError("stdin and stdout can't be removed")
+}
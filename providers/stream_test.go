@@ -0,0 +1,112 @@
+// Copyright © 2018 Hays Hutton <hays.hutton@gmail.com>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// stdin piped to a blob of unknown length and back out to stdout, as in
+// 'stor cp - //alias/blob' followed by 'stor cp //alias/blob -'.
+func TestStreamRoundTrip(t *testing.T) {
+	fake := newFakeBlobs()
+	defer fake.Close()
+	azure := fake.provider()
+
+	data := make([]byte, 3*BlockSize()+100)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	stdin := &StreamProvider{In: bytes.NewReader(data)}
+	stream := make(chan *Block, 4)
+	err := stdin.Open(STDIO, stream, InitTokenBucket(), UNKNOWN, BlockSize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = azure.Create("piped", stream, UNKNOWN, UNKNOWN, InitTokenBucket(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := fake.blob("piped")
+	if !bytes.Equal(stored, data) {
+		t.Fatalf("stored %d bytes, want the %d piped in", len(stored), len(data))
+	}
+
+	var out bytes.Buffer
+	copyThrough(t, azure, "piped", &StreamProvider{Out: &out}, STDIO)
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("wrote %d bytes to stdout, want the %d piped in", out.Len(), len(data))
+	}
+	buffersReturned(t)
+}
+
+func TestStreamWritesInOrder(t *testing.T) {
+	stream := make(chan *Block, 3)
+	for _, ordinal := range []int{2, 0, 1} {
+		buffer := GetBuffer(1)
+		buffer[0] = byte('a' + ordinal)
+		stream <- &Block{Bytes: buffer, Ordinal: ordinal}
+	}
+	close(stream)
+
+	var out bytes.Buffer
+	err := (&StreamProvider{Out: &out}).Create(STDIO, stream, 3, 3, InitTokenBucket(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "abc" {
+		t.Errorf("wrote %q, want %q", out.String(), "abc")
+	}
+	buffersReturned(t)
+}
+
+func TestStreamBlockLimit(t *testing.T) {
+	tests := []struct {
+		length int
+		err    bool
+	}{
+		{MAX_BLOCKS, false},
+		{MAX_BLOCKS + 1, true},
+	}
+	for _, test := range tests {
+		stdin := &StreamProvider{In: bytes.NewReader(make([]byte, test.length))}
+		stream := make(chan *Block)
+		err := stdin.Open(STDIO, stream, InitTokenBucket(), UNKNOWN, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blocks := 0
+		var streamErr error
+		for block := range stream {
+			if block.Err != nil {
+				streamErr = block.Err
+				continue
+			}
+			blocks++
+			PutBuffer(block.Bytes)
+		}
+		if test.err && KindOf(streamErr) != InvalidConfig {
+			t.Errorf("%d bytes: err = %v, want InvalidConfig", test.length, streamErr)
+		}
+		if !test.err && streamErr != nil {
+			t.Errorf("%d bytes: %v", test.length, streamErr)
+		}
+		if blocks != MAX_BLOCKS {
+			t.Errorf("%d bytes: read %d blocks, want %d", test.length, blocks, MAX_BLOCKS)
+		}
+	}
+	buffersReturned(t)
+}